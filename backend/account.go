@@ -0,0 +1,102 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"time"
+)
+
+// Account is a player's identity across sessions. Clients keep the account's
+// ID and secret token and present them when connecting; the server only
+// stores a hash of the token.
+type Account struct {
+	ID        string    `json:"id"`
+	TokenHash string    `json:"tokenHash"`
+	Name      string    `json:"name"`
+	Rating    float64   `json:"rating"`
+	Created   time.Time `json:"created"`
+	LastSeen  time.Time `json:"lastSeen"`
+	// Achievements maps the keys of the unlocked achievements to when they
+	// were unlocked
+	Achievements map[string]time.Time `json:"achievements,omitempty"`
+}
+
+// Lengths of the random parts of an account in bytes, hex-encoded in the
+// player ID and token.
+const (
+	playerIDBytes = 16
+	tokenBytes    = 32
+)
+
+// CreateAccount creates an account with a new ID and token and returns them.
+func (s *Store) CreateAccount(name string) (Account, string, error) {
+	token := randomHex(tokenBytes)
+	now := time.Now()
+
+	a := Account{
+		ID:        randomHex(playerIDBytes),
+		TokenHash: hashToken(token),
+		Name:      name,
+		Rating:    defaultRating,
+		Created:   now,
+		LastSeen:  now,
+	}
+
+	if err := s.accounts.put(a.ID, a); err != nil {
+		return Account{}, "", err
+	}
+	return a, token, nil
+}
+
+// Authenticate returns the account with the given ID if token is its token,
+// recording that it was seen under the given name.
+func (s *Store) Authenticate(id, token, name string) (Account, bool) {
+	a, ok := s.accounts.get(id)
+	if !ok || subtle.ConstantTimeCompare([]byte(a.TokenHash), []byte(hashToken(token))) != 1 {
+		return Account{}, false
+	}
+
+	err := s.accounts.update(id, func(a *Account) {
+		a.Name = name
+		a.LastSeen = time.Now()
+	})
+	if err != nil {
+		return Account{}, false
+	}
+
+	a, _ = s.accounts.get(id)
+	return a, true
+}
+
+// Account returns the account with the given ID.
+func (s *Store) Account(id string) (Account, bool) {
+	return s.accounts.get(id)
+}
+
+// SetRating stores a player's new rating.
+func (s *Store) SetRating(id string, rating float64) error {
+	// accounts are never deleted, so one found stays
+	if _, ok := s.accounts.get(id); !ok {
+		return errAccountNotFound
+	}
+	return s.accounts.update(id, func(a *Account) {
+		a.Rating = rating
+	})
+}
+
+var errAccountNotFound = errors.New("account not found")
+
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// randomHex returns n random bytes in hex.
+func randomHex(n int) string {
+	b := make([]byte, n)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestAccountCreatedOnRegister(t *testing.T) {
+	config := DefaultConfig()
+	config.StartDelay = time.Minute
+	// the smallest limit must still let returning players register
+	config.MaxMessageSize = maxRegisterSize
+	if err := config.validate(); err != nil {
+		t.Fatal(err)
+	}
+	h := newTestHub(t, config)
+	server := httptest.NewServer(http.HandlerFunc(h.ServeWs))
+	defer server.Close()
+
+	// connections that never register don't get an account
+	for range 5 {
+		dialWs(t, server, "lurker").Close()
+	}
+	for h.conns.count() > 0 {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if n := accountCount(h.store); n != 0 {
+		t.Fatalf("%d accounts after connecting without registering, want 0", n)
+	}
+
+	c := dialTestClient(t, server, "p", "", "")
+	if msg := c.next(); msg[0] != OpcodeHubGreeting {
+		t.Fatalf("got opcode %d, want hub greeting", msg[0])
+	}
+	created := c.next()
+	if created[0] != OpcodeAccountCreated {
+		t.Fatalf("got opcode %d, want account created", created[0])
+	}
+	idLen := int(created[1])
+	id := string(created[2 : 2+idLen])
+	token := string(created[3+idLen : 3+idLen+int(created[2+idLen])])
+	c.conn.Close()
+
+	c = dialTestClient(t, server, strings.Repeat("p", 255), id, token)
+	if msg := c.next(); msg[0] != OpcodeHubGreeting {
+		t.Fatalf("got opcode %d, want hub greeting", msg[0])
+	}
+	if msg := c.next(); msg[0] != OpcodeLobbyGreeting {
+		t.Errorf("got opcode %d after logging in, want lobby greeting", msg[0])
+	}
+	if n := accountCount(h.store); n != 1 {
+		t.Errorf("%d accounts after logging in again, want 1", n)
+	}
+}
+
+func accountCount(s *Store) int {
+	n := 0
+	s.accounts.each(func(string, Account) { n++ })
+	return n
+}
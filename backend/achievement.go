@@ -0,0 +1,160 @@
+package main
+
+import (
+	"slices"
+	"time"
+)
+
+// Achievements, the ids sent in AchievementUnlocked.
+const (
+	StreakAchievement byte = iota
+	NoPowerupWinAchievement
+	FastDifficultyAchievement
+	HardModeEliminationsAchievement
+)
+
+// Achievement is unlocked once per player, the first time check holds after
+// a game event.
+type Achievement struct {
+	// key identifies the achievement in accounts, unlike its id it must
+	// never change
+	key         string
+	name        string
+	description string
+	check       func(c *Client, e gameEvent) bool
+}
+
+var Achievements = []Achievement{
+	StreakAchievement: {
+		key:         "streak_20",
+		name:        "On a Roll",
+		description: "Answer 20 questions in a row correctly",
+		check: func(c *Client, e gameEvent) bool {
+			return e.kind == eventAnswered && c.streak >= 20
+		},
+	},
+	NoPowerupWinAchievement: {
+		key:         "no_powerup_win",
+		name:        "Purist",
+		description: "Win a game against others without buying powerups",
+		check: func(c *Client, e gameEvent) bool {
+			var bought uint
+			for _, n := range c.powerupsBought {
+				bought += n
+			}
+			return e.kind == eventGameFinished && e.players > 1 && c.place == 1 && bought == 0
+		},
+	},
+	FastDifficultyAchievement: {
+		key:         "fast_difficulty_10",
+		name:        "Speedrunner",
+		description: "Reach difficulty 10 within 2 minutes of the start",
+		check: func(c *Client, e gameEvent) bool {
+			return e.kind == eventAnswered && c.difficulty >= 10 && e.elapsed < 2*time.Minute
+		},
+	},
+	HardModeEliminationsAchievement: {
+		key:         "hard_mode_eliminations_3",
+		name:        "Saboteur",
+		description: "Eliminate 3 players in one game after hitting them with HardMode",
+		check: func(c *Client, e gameEvent) bool {
+			return e.kind == eventOpponentEliminated && c.hardModeEliminations >= 3
+		},
+	},
+}
+
+type gameEventKind int
+
+const (
+	// eventAnswered follows every answer of the client
+	eventAnswered gameEventKind = iota
+	// eventOpponentEliminated follows the elimination of a player still
+	// on the question the client hit them with HardMode
+	eventOpponentEliminated
+	// eventGameFinished follows placing the client at the end of the game
+	eventGameFinished
+)
+
+// gameEvent is something that happened to a client in a game, after which
+// its achievements are checked.
+type gameEvent struct {
+	kind gameEventKind
+	// elapsed is the time since the game started
+	elapsed time.Duration
+	// players is the number of players in the game, set when it finished
+	players int
+}
+
+// UnlockedAchievement is an achievement in a player's profile.
+type UnlockedAchievement struct {
+	Key         string    `json:"key"`
+	Name        string    `json:"name"`
+	Description string    `json:"description"`
+	Unlocked    time.Time `json:"unlocked"`
+}
+
+// UnlockAchievement stores that a player unlocked the achievement with the
+// given key.
+func (s *Store) UnlockAchievement(id, key string) error {
+	if _, ok := s.accounts.get(id); !ok {
+		return errAccountNotFound
+	}
+	return s.accounts.update(id, func(a *Account) {
+		if _, ok := a.Achievements[key]; ok {
+			return
+		}
+		if a.Achievements == nil {
+			a.Achievements = map[string]time.Time{}
+		}
+		a.Achievements[key] = time.Now()
+	})
+}
+
+// unlockedAchievements lists the achievements unlocked in an account, the
+// earliest first. Keys of achievements that no longer exist are skipped.
+func unlockedAchievements(a Account) []UnlockedAchievement {
+	unlocked := []UnlockedAchievement{}
+	for _, ach := range Achievements {
+		if t, ok := a.Achievements[ach.key]; ok {
+			unlocked = append(unlocked, UnlockedAchievement{
+				Key:         ach.key,
+				Name:        ach.name,
+				Description: ach.description,
+				Unlocked:    t,
+			})
+		}
+	}
+
+	slices.SortFunc(unlocked, func(a, b UnlockedAchievement) int {
+		return a.Unlocked.Compare(b.Unlocked)
+	})
+	return unlocked
+}
+
+// checkAchievements unlocks the achievements of c that the event completed,
+// telling the client about them.
+func (l *Lobby) checkAchievements(c *Client, e gameEvent) {
+	for id, a := range Achievements {
+		if c.achievements[a.key] || !a.check(c, e) {
+			continue
+		}
+
+		c.achievements[a.key] = true
+		c.log().Info("unlocked achievement", "achievement", a.key)
+
+		if err := l.hub.store.UnlockAchievement(c.playerID, a.key); err != nil {
+			c.log().Error("error saving achievement", "achievement", a.key, "err", err)
+		}
+		c.send(AchievementUnlocked{Achievement: byte(id)})
+	}
+}
+
+// hasAchievements returns the set of keys of the achievements unlocked in
+// an account.
+func hasAchievements(a Account) map[string]bool {
+	keys := make(map[string]bool, len(a.Achievements))
+	for key := range a.Achievements {
+		keys[key] = true
+	}
+	return keys
+}
@@ -0,0 +1,304 @@
+package main
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/pprof"
+	"runtime"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// HubState is a snapshot of the hub for debugging.
+type HubState struct {
+	Draining    bool         `json:"draining"`
+	Connections int          `json:"connections"`
+	Queued      int          `json:"queued"`
+	Goroutines  int          `json:"goroutines"`
+	Lobbies     []LobbyState `json:"lobbies"`
+}
+
+type LobbyState struct {
+	ID      int       `json:"id"`
+	State   string    `json:"state"`
+	Open    bool      `json:"open"`
+	Private bool      `json:"private"`
+	Code    string    `json:"code,omitempty"`
+	Players int       `json:"players"`
+	Created time.Time `json:"created"`
+	Elapsed string    `json:"elapsed"`
+}
+
+// LobbyDetails is a lobby's state including its players, see
+// HubLobbyInspect.
+type LobbyDetails struct {
+	LobbyState
+	GameTime string          `json:"gameTime,omitempty"`
+	Players  []PlayerDetails `json:"players"`
+}
+
+type PlayerDetails struct {
+	ID           ClientId `json:"id"`
+	Name         string   `json:"name"`
+	Score        uint     `json:"score"`
+	Coins        uint     `json:"coins"`
+	Difficulty   uint     `json:"difficulty"`
+	Eliminated   bool     `json:"eliminated"`
+	Disconnected bool     `json:"disconnected"`
+}
+
+// State returns a snapshot of the hub and its lobbies.
+func (h *Hub) State() HubState {
+	h.lobbiesMu.Lock()
+	defer h.lobbiesMu.Unlock()
+
+	s := HubState{
+		Draining:    h.draining.Load(),
+		Connections: h.conns.count(),
+		Queued:      len(h.registerClientQueue),
+		Goroutines:  runtime.NumGoroutine(),
+		Lobbies:     make([]LobbyState, 0, len(h.lobbies)),
+	}
+
+	for _, l := range h.lobbies {
+		s.Lobbies = append(s.Lobbies, l.state())
+	}
+
+	return s
+}
+
+// state describes the lobby. Unlike details it is safe to call from any
+// goroutine.
+func (l *Lobby) state() LobbyState {
+	state := "waiting"
+	if l.hasStarted() {
+		state = "playing"
+	}
+
+	return LobbyState{
+		ID:      l.id,
+		State:   state,
+		Open:    l.open.Load(),
+		Private: l.private,
+		Code:    l.code,
+		Players: l.clientCount(),
+		Created: l.createdAt,
+		Elapsed: time.Since(l.createdAt).Round(time.Second).String(),
+	}
+}
+
+// adminRoutes serves the endpoints for operators only, on a listener that
+// shouldn't be reachable by players. The /api endpoints also require the
+// admin token and are disabled without one.
+func adminRoutes(hub *Hub) *http.ServeMux {
+	mux := http.NewServeMux()
+
+	mux.HandleFunc("/debug/pprof/", pprof.Index)
+	mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+
+	mux.HandleFunc("GET /debug/hub", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, hub.State())
+	})
+
+	mux.HandleFunc("GET /metrics", hub.ServeMetrics)
+
+	api := adminAPI{hub: hub}
+	auth := requireToken(hub.config.AdminToken)
+
+	mux.Handle("GET /api/lobbies", auth(api.listLobbies))
+	mux.Handle("GET /api/lobbies/{id}", auth(api.inspectLobby))
+	mux.Handle("POST /api/lobbies/{id}/start", auth(api.startLobby))
+	mux.Handle("POST /api/lobbies/{id}/close", auth(api.closeLobby))
+	mux.Handle("POST /api/lobbies/{id}/players/{client}/kick", auth(api.kickClient))
+	mux.Handle("POST /api/lobbies/{id}/announce", auth(api.announce))
+	mux.Handle("POST /api/announce", auth(api.announceAll))
+
+	return mux
+}
+
+// requireToken only lets requests with the bearer token through.
+func requireToken(token string) func(http.HandlerFunc) http.Handler {
+	return func(next http.HandlerFunc) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if token == "" {
+				writeError(w, http.StatusForbidden, errors.New("admin api disabled, no admin token configured"))
+				return
+			}
+
+			given, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+			if !ok || subtle.ConstantTimeCompare([]byte(given), []byte(token)) != 1 {
+				w.Header().Set("WWW-Authenticate", "Bearer")
+				writeError(w, http.StatusUnauthorized, errors.New("invalid admin token"))
+				return
+			}
+
+			next(w, r)
+		})
+	}
+}
+
+// adminAPI controls lobbies through hub->lobby messages, so the lobbies'
+// state stays owned by their goroutines.
+type adminAPI struct {
+	hub *Hub
+}
+
+func (a adminAPI) listLobbies(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, a.hub.State().Lobbies)
+}
+
+func (a adminAPI) inspectLobby(w http.ResponseWriter, r *http.Request) {
+	l, ok := a.lobby(w, r)
+	if !ok {
+		return
+	}
+
+	reply := make(chan LobbyDetails, 1)
+	if !l.notify(HubLobbyInspect{Reply: reply}) {
+		writeError(w, http.StatusNotFound, errLobbyNotFound)
+		return
+	}
+	writeJSON(w, http.StatusOK, <-reply)
+}
+
+func (a adminAPI) startLobby(w http.ResponseWriter, r *http.Request) {
+	l, ok := a.lobby(w, r)
+	if !ok {
+		return
+	}
+
+	if l.hasStarted() {
+		writeError(w, http.StatusConflict, errors.New("lobby already started"))
+		return
+	}
+	a.send(w, l, HubLobbyStart{})
+}
+
+func (a adminAPI) closeLobby(w http.ResponseWriter, r *http.Request) {
+	l, ok := a.lobby(w, r)
+	if !ok {
+		return
+	}
+	a.send(w, l, HubLobbyClose{})
+}
+
+func (a adminAPI) kickClient(w http.ResponseWriter, r *http.Request) {
+	l, ok := a.lobby(w, r)
+	if !ok {
+		return
+	}
+
+	id, err := strconv.Atoi(r.PathValue("client"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, errors.New("invalid client id"))
+		return
+	}
+
+	reply := make(chan error, 1)
+	if !l.notify(HubLobbyKick{ClientID: id, Reply: reply}) {
+		writeError(w, http.StatusNotFound, errLobbyNotFound)
+		return
+	}
+	if err := <-reply; err != nil {
+		writeError(w, http.StatusNotFound, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (a adminAPI) announce(w http.ResponseWriter, r *http.Request) {
+	l, ok := a.lobby(w, r)
+	if !ok {
+		return
+	}
+
+	text, ok := readAnnouncement(w, r)
+	if !ok {
+		return
+	}
+	a.send(w, l, HubLobbyAnnounce{Text: text})
+}
+
+func (a adminAPI) announceAll(w http.ResponseWriter, r *http.Request) {
+	text, ok := readAnnouncement(w, r)
+	if !ok {
+		return
+	}
+
+	a.hub.lobbiesMu.Lock()
+	lobbies := slices.Clone(a.hub.lobbies)
+	a.hub.lobbiesMu.Unlock()
+
+	for _, l := range lobbies {
+		l.notify(HubLobbyAnnounce{Text: text})
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+var errLobbyNotFound = errors.New("lobby not found")
+
+// lobby returns the lobby named by the request's path, answering the request
+// if there is none.
+func (a adminAPI) lobby(w http.ResponseWriter, r *http.Request) (*Lobby, bool) {
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, errors.New("invalid lobby id"))
+		return nil, false
+	}
+
+	l := a.hub.lobby(id)
+	if l == nil {
+		writeError(w, http.StatusNotFound, errLobbyNotFound)
+		return nil, false
+	}
+	return l, true
+}
+
+// send delivers msg to the lobby and answers the request.
+func (a adminAPI) send(w http.ResponseWriter, l *Lobby, msg HubLobbyMessage) {
+	if !l.notify(msg) {
+		writeError(w, http.StatusNotFound, errLobbyNotFound)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// readAnnouncement reads the text of an announcement from a JSON body like
+// {"text": "..."}.
+func readAnnouncement(w http.ResponseWriter, r *http.Request) (string, bool) {
+	var body struct {
+		Text string `json:"text"`
+	}
+
+	err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 64<<10)).Decode(&body)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return "", false
+	}
+	if body.Text == "" || len(body.Text) > 1000 {
+		writeError(w, http.StatusBadRequest, errors.New("text must be between 1 and 1000 bytes"))
+		return "", false
+	}
+
+	return body.Text, true
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+
+	e := json.NewEncoder(w)
+	e.SetIndent("", "  ")
+	e.Encode(v)
+}
+
+func writeError(w http.ResponseWriter, status int, err error) {
+	writeJSON(w, status, map[string]string{"error": err.Error()})
+}
@@ -0,0 +1,145 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// adminRequest sends a request to the admin API with the given token, left
+// out if empty, and returns the response status.
+func adminRequest(t *testing.T, server *httptest.Server, method, path, token string) int {
+	t.Helper()
+
+	req, err := http.NewRequest(method, server.URL+path, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	return resp.StatusCode
+}
+
+func TestAdminAuth(t *testing.T) {
+	config := DefaultConfig()
+	config.AdminToken = "secret"
+	admin := httptest.NewServer(adminRoutes(newTestHub(t, config)))
+	defer admin.Close()
+
+	for _, tt := range []struct {
+		token  string
+		status int
+	}{
+		{"", http.StatusUnauthorized},
+		{"wrong", http.StatusUnauthorized},
+		{"secretsecret", http.StatusUnauthorized},
+		{"secret", http.StatusOK},
+	} {
+		if status := adminRequest(t, admin, "GET", "/api/lobbies", tt.token); status != tt.status {
+			t.Errorf("token %q: got status %d, want %d", tt.token, status, tt.status)
+		}
+	}
+
+	// without a configured token the api is disabled, even for an empty
+	// bearer token
+	disabled := httptest.NewServer(adminRoutes(newTestHub(t, DefaultConfig())))
+	defer disabled.Close()
+
+	req, _ := http.NewRequest("GET", disabled.URL+"/api/lobbies", nil)
+	req.Header.Set("Authorization", "Bearer ")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusForbidden {
+		t.Errorf("disabled api: got status %d, want %d", resp.StatusCode, http.StatusForbidden)
+	}
+}
+
+func TestAdminLobbyControl(t *testing.T) {
+	const token = "secret"
+
+	config := DefaultConfig()
+	config.AdminToken = token
+	config.ClientsPerLobby = 4
+	config.StartDelay = time.Minute
+	h := newTestHub(t, config)
+
+	server := httptest.NewServer(http.HandlerFunc(h.ServeWs))
+	defer server.Close()
+	admin := httptest.NewServer(adminRoutes(h))
+	defer admin.Close()
+
+	kicked := dialTestClient(t, server, "kicked", "", "")
+	player := dialTestClient(t, server, "player", "", "")
+	for _, c := range []*testClient{kicked, player} {
+		for c.next()[0] != OpcodeLobbyGreeting {
+		}
+	}
+
+	lobbies := h.State().Lobbies
+	if len(lobbies) != 1 {
+		t.Fatalf("%d lobbies, want 1", len(lobbies))
+	}
+	lobby := "/api/lobbies/" + strconv.Itoa(lobbies[0].ID)
+
+	for _, tt := range []struct {
+		method, path string
+		status       int
+	}{
+		{"POST", "/api/lobbies/99/start", http.StatusNotFound},
+		{"POST", "/api/lobbies/x/start", http.StatusBadRequest},
+		{"POST", lobby + "/players/99/kick", http.StatusNotFound},
+		// the first client joined first and got id 0
+		{"POST", lobby + "/players/0/kick", http.StatusNoContent},
+		{"POST", lobby + "/players/0/kick", http.StatusNotFound},
+	} {
+		if status := adminRequest(t, admin, tt.method, tt.path, token); status != tt.status {
+			t.Errorf("%s %s: got status %d, want %d", tt.method, tt.path, status, tt.status)
+		}
+	}
+
+	// a kicked client's connection is closed
+	kicked.conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	for {
+		if _, _, err := kicked.conn.ReadMessage(); err != nil {
+			break
+		}
+	}
+
+	if status := adminRequest(t, admin, "POST", lobby+"/start", token); status != http.StatusNoContent {
+		t.Fatalf("starting the lobby: got status %d", status)
+	}
+	for player.next()[0] != OpcodeStartGame {
+	}
+	if status := adminRequest(t, admin, "POST", lobby+"/start", token); status != http.StatusConflict {
+		t.Errorf("starting the lobby again: got status %d, want %d", status, http.StatusConflict)
+	}
+
+	if status := adminRequest(t, admin, "POST", lobby+"/close", token); status != http.StatusNoContent {
+		t.Fatalf("closing the lobby: got status %d", status)
+	}
+	player.conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	for {
+		if _, _, err := player.conn.ReadMessage(); err != nil {
+			break
+		}
+	}
+	// the lobby unregisters itself after closing its clients' connections
+	for deadline := time.Now().Add(5 * time.Second); len(h.State().Lobbies) > 0; {
+		if time.Now().After(deadline) {
+			t.Fatal("closed lobby still registered")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
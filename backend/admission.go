@@ -0,0 +1,111 @@
+package main
+
+import (
+	"errors"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+)
+
+var (
+	errServerFull       = errors.New("server full")
+	errTooManyFromIP    = errors.New("too many connections from this address")
+	errOriginNotAllowed = errors.New("origin not allowed")
+)
+
+// connLimiter caps the number of concurrent websocket connections, both in
+// total and per remote IP. A limit of 0 means no limit.
+type connLimiter struct {
+	max      int
+	maxPerIP int
+
+	mu    sync.Mutex
+	total int
+	perIP map[string]int
+}
+
+func newConnLimiter(max, maxPerIP int) *connLimiter {
+	return &connLimiter{
+		max:      max,
+		maxPerIP: maxPerIP,
+		perIP:    map[string]int{},
+	}
+}
+
+// acquire reserves a connection for ip. Every successful acquire must be
+// followed by exactly one release.
+func (l *connLimiter) acquire(ip string) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.maxPerIP > 0 && l.perIP[ip] >= l.maxPerIP {
+		return errTooManyFromIP
+	}
+	if l.max > 0 && l.total >= l.max {
+		return errServerFull
+	}
+
+	l.total++
+	l.perIP[ip]++
+	return nil
+}
+
+// count returns the number of connections currently held.
+func (l *connLimiter) count() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	return l.total
+}
+
+func (l *connLimiter) release(ip string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.total--
+	if l.perIP[ip]--; l.perIP[ip] <= 0 {
+		delete(l.perIP, ip)
+	}
+}
+
+// checkOrigin returns a websocket origin check accepting the server's own
+// origin and the allowed ones. Requests without an Origin header don't come
+// from a browser and are accepted.
+func checkOrigin(allowed []string) func(r *http.Request) bool {
+	return func(r *http.Request) bool {
+		origin := r.Header.Get("Origin")
+		if origin == "" {
+			return true
+		}
+
+		if u, err := url.Parse(origin); err == nil && strings.EqualFold(u.Host, r.Host) {
+			return true
+		}
+
+		for _, a := range allowed {
+			if a == "*" || strings.EqualFold(a, origin) {
+				return true
+			}
+		}
+
+		logRejection(r, errOriginNotAllowed)
+		return false
+	}
+}
+
+// remoteIP returns the IP address of the peer that sent r.
+func remoteIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+func logRejection(r *http.Request, reason error) {
+	slog.Warn("rejected connection", "remote_ip", remoteIP(r),
+		"origin", r.Header.Get("Origin"), "reason", reason)
+}
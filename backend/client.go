@@ -2,35 +2,119 @@ package main
 
 import (
 	"errors"
-	"fmt"
-	"log"
+	"log/slog"
+	"math/rand/v2"
 	"net"
+	"sync"
 	"sync/atomic"
+	"time"
 
 	"github.com/gorilla/websocket"
 )
 
+const (
+	// writeWait is how long a single write may take.
+	writeWait = 10 * time.Second
+
+	// pongWait is how long the client may stay silent, including not
+	// answering pings, before its connection is considered dead.
+	pongWait = 60 * time.Second
+
+	// pingPeriod must be shorter than pongWait so the pong can arrive.
+	pingPeriod = pongWait * 9 / 10
+)
+
+// sendQueueStats tracks the health of all clients' outbound queues.
+var sendQueueStats struct {
+	// dropped counts stale messages thrown away because a queue was full
+	dropped atomic.Uint64
+	// disconnected counts clients dropped for falling too far behind
+	disconnected atomic.Uint64
+	// queues holds the queue of every connected client as a key
+	queues sync.Map
+}
+
+// sendQueueDepth returns the length of the longest send queue and the
+// number of messages waiting in all of them.
+func sendQueueDepth() (longest, total int) {
+	sendQueueStats.queues.Range(func(q, _ any) bool {
+		n := len(q.(chan ServerMessage))
+		longest = max(longest, n)
+		total += n
+		return true
+	})
+	return longest, total
+}
+
 type Client struct {
 	id   int
 	name string
+	// playerID is the client's account
+	playerID string
 
 	conn       *websocket.Conn
-	connClosed bool
+	connClosed atomic.Bool
+
+	hub    *Hub
+	config *Config
 
-	hub *Hub
+	// rating is the player's rating when it connected
+	rating float64
+	// achievements holds the keys of the player's unlocked achievements,
+	// owned by the lobby once the client is registered
+	achievements map[string]bool
+
+	// release frees the client's connection slot once the connection closed
+	release func()
+
+	// logger gains the lobby's attributes once the client is registered
+	logger   atomic.Pointer[slog.Logger]
+	messages *messageLog
 
 	// roomWrite chan PendingMessage
 	read       chan ClientLobbyMessage
 	write      chan ServerMessage
 	unregister chan *Client
 
-	statusEffects [StatusEffectCount]atomic.Bool
+	// lobbyDone is closed once the lobby stops receiving on read and
+	// unregister
+	lobbyDone <-chan struct{}
 
-	closed atomic.Bool
-
-	playing atomic.Bool
+	statusEffects [StatusEffectCount]atomic.Bool
 
+	// The fields below are game state owned by the lobby. They must only be
+	// accessed from the lobby's goroutine.
+
+	eliminated bool
+	playing    bool
+	// place is where the client finished the game, 0 until it is out
+	place int
+
+	// lastSubmission is when the client last submitted an answer, and
+	// warnedIdle whether it was warned about being idle since
+	lastSubmission time.Time
+	warnedIdle     bool
+
+	// questionSentAt is when the current question was sent and
+	// questionDifficulty its difficulty
+	questionSentAt     time.Time
+	questionDifficulty uint
+
+	// disconnected is set once write has been closed
+	disconnected bool
+	// lagging is set once the client was disconnected for a full queue
+	lagging bool
+	// staleLeaderboard is set when a leaderboard snapshot was dropped, so
+	// the next one sent to the client has to be complete
+	staleLeaderboard bool
+
+	questions QuestionGenerator
+	// rng is the lobby's source of randomness for questions
+	rng *rand.Rand
+
+	multipleChoice bool
 	expectedResult int
+	expectedChoice byte
 	score          uint
 	coins          uint
 
@@ -39,185 +123,350 @@ type Client struct {
 
 	difficulty uint
 	answered   uint
+
+	recentQuestions questionHistory
+
+	// totals over the game, recorded in the match history
+	correctAnswers uint
+	wrongAnswers   uint
+	coinsEarned    uint
+	coinsSpent     uint
+	maxDifficulty  uint
+	powerupsBought [HardModePowerup + 1]uint
+	// answersByDifficulty is indexed by the difficulty of the questions
+	answersByDifficulty [11]answerTotals
+
+	// streak counts the correct answers since the last wrong one
+	streak uint
+	// hardModeBy is the player that hit the client with HardMode, who is
+	// credited with eliminating it until the hard question is replaced
+	hardModeBy           *Client
+	hardModeEliminations uint
 }
 
+// readPump forwards the client's messages to its lobby until the connection
+// closes, then unregisters the client.
 func (c *Client) readPump() {
+	c.conn.SetReadDeadline(time.Now().Add(pongWait))
+	c.conn.SetPongHandler(func(string) error {
+		return c.conn.SetReadDeadline(time.Now().Add(pongWait))
+	})
+
+	limiter := newClientRateLimiter(c.config.RateLimits)
+
 	for {
 		_, message, err := c.conn.ReadMessage()
 
 		if err != nil {
-			c.connClosed = true
+			c.connClosed.Store(true)
 			if websocket.IsCloseError(err,
 				websocket.CloseNormalClosure,
 				websocket.CloseGoingAway,
 				websocket.CloseAbnormalClosure,
 			) || errors.Is(err, net.ErrClosed) {
-				c.log("Tried to read, websocket closed")
+				c.log().Debug("websocket closed while reading")
 				break
 			}
-			c.log("error reading message from websocket: %+v", err.Error())
+			c.log().Warn("error reading message from websocket", "err", err)
 			break
 		}
 
-		if c.closed.Load() {
+		c.conn.SetReadDeadline(time.Now().Add(pongWait))
+
+		if len(message) == 0 {
+			continue
+		}
+
+		opcode := message[0]
+		metrics.received[opcode].Add(1)
+
+		verdict := limiter.check(opcode, time.Now())
+		if verdict == rateKick {
+			c.log().Warn("rate limit exceeded, kicking", "opcode", opcode)
+			c.conn.WriteControl(websocket.CloseMessage,
+				websocket.FormatCloseMessage(websocket.ClosePolicyViolation, "rate limit exceeded"),
+				time.Now().Add(writeWait))
+			break
+		}
+		if verdict == rateWarn {
+			c.log().Info("rate limited, warning", "opcode", opcode)
+			if !c.toLobby(ClientLobbyRateLimited{ClientID: c.id, Throttled: opcode}) {
+				break
+			}
+		}
+		if verdict != rateAllow {
+			metrics.rateLimited[opcode].Add(1)
 			continue
 		}
 
 		clientMessage, err := ParseClientMessage(message)
 
 		if err != nil {
-			c.log("error parsing client message: %+v", err)
+			c.log().Warn("error parsing client message", "opcode", opcode, "err", err)
 			continue
 		}
 
-		c.log("received client message: %d %T",
-			clientMessage.Opcode(), clientMessage)
+		if c.messages.enabled(clientMessage) {
+			c.log().Debug("received message", "opcode", opcode,
+				"type", messageName(clientMessage))
+		}
+
+		var msg ClientLobbyMessage
 
 		switch clientMessage := clientMessage.(type) {
 
 		case *SkipWait:
-			c.read <- ClientLobbySkipWait{}
+			msg = ClientLobbySkipWait{}
 
 		case *Submission:
-			if !c.playing.Load() {
-				break
-			}
-
-			if c.expectedResult != int(clientMessage.Answer) {
-				break
+			msg = ClientLobbySubmission{
+				ClientID: c.id,
+				Answer:   clientMessage.Answer,
 			}
 
-			c.answered++
-			if c.answered%5 == 0 {
-				c.answered = 0
-				if c.difficulty < 10 {
-					c.difficulty++
-				}
+		case *ChoiceSubmission:
+			msg = ClientLobbyChoiceSubmission{
+				ClientID: c.id,
+				Choice:   clientMessage.Choice,
 			}
 
-			c.score += uint(100 * c.scoreMult)
-			c.coins += uint(10 * c.coinMult)
-
-			c.write <- CorrectSubmission{
-				NewScore: uint32(c.score),
-				NewCoins: uint32(c.coins),
+		case *PowerupPurchase:
+			msg = ClientLobbyPowerupPurchase{
+				ClientID:       c.id,
+				PowerupID:      clientMessage.PowerupID,
+				AffectedPlayer: int(clientMessage.AffectedPlayer),
 			}
 
-			c.read <- ClientLobbySubmission{
+		case *LeaderboardRequest:
+			msg = ClientLobbyLeaderboardRequest{
 				ClientID: c.id,
-				NewScore: c.score,
+				Period:   clientMessage.Period,
+				Category: clientMessage.Category,
 			}
 
-			question, expectedResult := GenerateQuestion(c.difficulty)
-			c.expectedResult = expectedResult
+		default:
+			continue
+		}
 
-			c.write <- NewQuestion{
-				Difficulty: byte(c.difficulty),
-				Question:   question,
-			}
+		if !c.toLobby(msg) {
+			break
+		}
+	}
 
-		case *PowerupPurchase:
-			if clientMessage.PowerupID >= byte(len(Powerups)) {
-				c.log("received invalid powerup id: %d",
-					clientMessage.PowerupID)
-				break
-			}
+	c.log().Debug("unregistering")
 
-			powerup := Powerups[clientMessage.PowerupID]
+	select {
+	case c.unregister <- c:
+	case <-c.lobbyDone:
+	}
+}
 
-			if powerup.cost > c.coins {
-				break
-			}
+// toLobby forwards msg to the lobby, reporting false if the lobby closed.
+func (c *Client) toLobby(msg ClientLobbyMessage) bool {
+	select {
+	case c.read <- msg:
+		return true
+	case <-c.lobbyDone:
+		return false
+	}
+}
 
-			c.coins -= powerup.cost
+// send queues msg for the write pump without blocking. When the queue is
+// full, leaderboard snapshots are dropped, to be made up for by the next one,
+// and anything else disconnects the client, so a slow connection can't stall
+// its lobby.
+func (c *Client) send(msg ServerMessage) {
+	if c.disconnected || c.lagging {
+		return
+	}
 
-			switch clientMessage.PowerupID {
+	select {
+	case c.write <- msg:
+		return
+	default:
+	}
 
-			case CoinMultPowerup:
-				c.coinMult += 0.2
-				c.write <- MultipliersChanged{
-					ScoreMult: c.scoreMult,
-					CoinMult:  c.coinMult,
-				}
+	if _, ok := msg.(LeaderboardSnapshot); ok {
+		sendQueueStats.dropped.Add(1)
+		c.staleLeaderboard = true
+		return
+	}
 
-			case ScoreMultPowerup:
-				c.scoreMult += 0.1
-				c.write <- MultipliersChanged{
-					ScoreMult: c.scoreMult,
-					CoinMult:  c.coinMult,
-				}
+	c.log().Warn("send queue full, disconnecting", "opcode", msg.Opcode())
+	sendQueueStats.disconnected.Add(1)
 
-			case SkipQuestionPowerup:
-				question, expectedResult := GenerateQuestion(c.difficulty)
-				c.expectedResult = expectedResult
-				c.write <- NewQuestion{
-					Question:   question,
-					Difficulty: byte(c.difficulty),
-				}
+	// the read pump fails once the connection is closed, which unregisters
+	// the client from its lobby
+	c.lagging = true
+	c.conn.Close()
+}
 
-			case EasyModePowerup:
-				c.difficulty--
-				c.answered = 0
+// writePump sends messages from write until it is closed, then closes the
+// connection. It also pings the client so the read pump notices connections
+// that went away without closing.
+func (c *Client) writePump() {
+	pingTicker := time.NewTicker(pingPeriod)
+	defer pingTicker.Stop()
+	defer c.release()
+	defer c.conn.Close()
+
+	sendQueueStats.queues.Store(c.write, struct{}{})
+	defer sendQueueStats.queues.Delete(c.write)
+
+	// abort keeps draining write so the lobby never blocks on a dead
+	// connection
+	abort := func() {
+		c.conn.Close()
+		for range c.write {
+		}
+	}
 
-				question, expectedResult := GenerateQuestion(c.difficulty)
-				c.expectedResult = expectedResult
-				c.write <- NewQuestion{
-					Question:   question,
-					Difficulty: byte(c.difficulty),
+	for {
+		select {
+		case msg, ok := <-c.write:
+			if !ok {
+				if !c.connClosed.Load() {
+					c.conn.SetWriteDeadline(time.Now().Add(writeWait))
+					c.conn.WriteMessage(websocket.CloseMessage, []byte{})
 				}
+				c.log().Debug("write pump closed")
+				return
+			}
 
-			case DoubleTapPowerup, CoinLeakPowerup, HardModePowerup:
-				c.read <- ClientLobbyStatusEffect{
-					ClientID: int(clientMessage.AffectedPlayer),
-					Powerup:  clientMessage.PowerupID,
-				}
+			binaryMsg, err := msg.MarshalBinary()
+			if err != nil {
+				c.log().Error("error marshaling message", "opcode", msg.Opcode(), "err", err)
+				continue
 			}
 
-			c.write <- PurchaseConfirmed{
-				NewCoins: uint32(c.coins),
+			if c.messages.enabled(msg) {
+				c.log().Debug("sending message", "opcode", msg.Opcode(),
+					"type", messageName(msg))
+			}
+
+			c.conn.SetWriteDeadline(time.Now().Add(writeWait))
+			err = c.conn.WriteMessage(websocket.BinaryMessage, binaryMsg)
+
+			if err != nil {
+				if websocket.IsCloseError(err, websocket.CloseNormalClosure, websocket.CloseGoingAway) {
+					c.log().Debug("websocket closed while writing")
+				} else {
+					c.log().Warn("error writing message", "opcode", msg.Opcode(), "err", err)
+				}
+				abort()
+				return
+			}
+			metrics.sent[msg.Opcode()].Add(1)
+
+		case <-pingTicker.C:
+			c.conn.SetWriteDeadline(time.Now().Add(writeWait))
+			if err := c.conn.WriteMessage(websocket.PingMessage, nil); err != nil {
+				c.log().Warn("error sending ping", "err", err)
+				abort()
+				return
 			}
 		}
 	}
+}
 
-	c.log("unregistering")
+// submitted records that the client is still answering questions.
+func (c *Client) submitted() {
+	c.lastSubmission = time.Now()
+	c.warnedIdle = false
+}
 
-	c.closed.Store(true)
-	close(c.write)
+// correctAnswer awards the client for answering the current question and
+// moves on to the next one.
+func (c *Client) correctAnswer() {
+	c.answered++
+	if c.answered%5 == 0 {
+		c.answered = 0
+		if c.difficulty < 10 {
+			c.difficulty++
+		}
+	}
+
+	earned := uint(10 * c.coinMult)
+	c.score += uint(100 * c.scoreMult)
+	c.coins += earned
+	c.coinsEarned += earned
+
+	c.send(CorrectSubmission{
+		NewScore: uint32(c.score),
+		NewCoins: uint32(c.coins),
+	})
 
-	c.unregister <- c
+	c.nextQuestion(c.difficulty)
 }
 
-func (c *Client) writePump() {
-	for msg := range c.write {
-		binaryMsg, err := msg.MarshalBinary()
-		if err != nil {
-			c.log("error marshaing binary: %+v", err)
-			continue
-		}
+// nextQuestion generates a question of the given difficulty and sends it in
+// the client's question mode.
+func (c *Client) nextQuestion(difficulty uint) {
+	q := c.questions.Generate(c.rng, difficulty)
+	for i := 0; i < maxQuestionRerolls && c.recentQuestions.contains(q.Key()); i++ {
+		q = c.questions.Generate(c.rng, difficulty)
+	}
+	c.maxDifficulty = max(c.maxDifficulty, difficulty)
+	c.recentQuestions.add(q.Key(), c.config.RecentQuestionWindow)
+
+	c.expectedResult = q.Answer
+	c.questionSentAt = time.Now()
+	c.questionDifficulty = difficulty
+	// a HardMode question is over once it was answered or skipped
+	c.hardModeBy = nil
+
+	if !c.multipleChoice {
+		c.send(NewQuestion{
+			Difficulty: byte(difficulty),
+			Question:   q.Text,
+		})
+		return
+	}
 
-		c.log("sending message: %d", msg.Opcode())
+	choices, correct := q.Choices(c.rng, c.config.ChoiceCount)
+	c.expectedChoice = correct
+	c.send(NewChoiceQuestion{
+		Difficulty: byte(difficulty),
+		Question:   q.Text,
+		Choices:    choices,
+	})
+}
 
-		err = c.conn.WriteMessage(websocket.BinaryMessage, binaryMsg)
+// recordAnswer records an answer to the current question in the client's
+// totals and the metrics.
+func (c *Client) recordAnswer(correct bool) {
+	latency := time.Since(c.questionSentAt)
+	observeAnswer(latency, correct)
+
+	if correct {
+		c.correctAnswers++
+		c.streak++
+	} else {
+		c.wrongAnswers++
+		c.streak = 0
+	}
 
-		if err != nil {
-			if websocket.IsCloseError(err, websocket.CloseNormalClosure, websocket.CloseGoingAway) {
-				c.log("Tried to write, websocket closed")
-				break
-			}
-			c.log("error writing server message to json %v", err)
-			return
+	if c.questionDifficulty < uint(len(c.answersByDifficulty)) {
+		t := &c.answersByDifficulty[c.questionDifficulty]
+		t.answers++
+		if correct {
+			t.correct++
 		}
+		t.time += latency
 	}
+}
 
-	if !c.connClosed {
-		c.conn.WriteMessage(websocket.CloseMessage, []byte{})
-	}
-	c.log("writePump closed")
+// sendMultipliers tells the client about its current multipliers.
+func (c *Client) sendMultipliers() {
+	c.send(MultipliersChanged{
+		ScoreMult: c.scoreMult,
+		CoinMult:  c.coinMult,
+	})
 }
 
 // func (c *Client) doubleTapHandler() {
 // }
 
-func (c *Client) log(format string, v ...any) {
-	log.Printf("client %d: %s", c.id, fmt.Sprintf(format, v...))
+func (c *Client) log() *slog.Logger {
+	return c.logger.Load()
 }
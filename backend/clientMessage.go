@@ -18,14 +18,24 @@ const (
 	OpcodeSubmission
 	OpcodePowerup
 	OpcodeSkipWait
+	OpcodeChoiceSubmission
+	OpcodeLeaderboardRequest
 )
 
 // -------- Register --------
 
+// Register is the first message of a client. Clients with an account log in
+// to it with its player ID and token, which are left out otherwise.
 type Register struct {
-	Name string
+	Name     string
+	PlayerID string
+	Token    string
 }
 
+// maxRegisterSize is the size of a register message with the longest name
+// and the credentials of an account.
+const maxRegisterSize = 1 + 1 + 255 + 1 + 2*playerIDBytes + 1 + 2*tokenBytes
+
 func (*Register) Opcode() byte { return OpcodeRegister }
 
 func (r *Register) UnmarshalBinary(data []byte) error {
@@ -40,6 +50,19 @@ func (r *Register) UnmarshalBinary(data []byte) error {
 		return errors.New("register message truncated name")
 	}
 	r.Name = string(data[2 : 2+nameLen])
+
+	rest := data[2+nameLen:]
+	if len(rest) == 0 {
+		return nil
+	}
+
+	idLen := int(rest[0])
+	if len(rest) < 2+idLen || len(rest) < 2+idLen+int(rest[1+idLen]) {
+		return errors.New("register message truncated credentials")
+	}
+	r.PlayerID = string(rest[1 : 1+idLen])
+	tokenLen := int(rest[1+idLen])
+	r.Token = string(rest[2+idLen : 2+idLen+tokenLen])
 	return nil
 }
 
@@ -99,6 +122,48 @@ func (s *SkipWait) UnmarshalBinary(data []byte) error {
 	return nil
 }
 
+// -------- Choice Submission --------
+
+type ChoiceSubmission struct {
+	Choice byte
+}
+
+func (*ChoiceSubmission) Opcode() byte { return OpcodeChoiceSubmission }
+
+func (s *ChoiceSubmission) UnmarshalBinary(data []byte) error {
+	if len(data) < 2 {
+		return errors.New("choice submission message too short")
+	}
+	if data[0] != OpcodeChoiceSubmission {
+		return fmt.Errorf("invalid opcode %d for ChoiceSubmission", data[0])
+	}
+	s.Choice = data[1]
+	return nil
+}
+
+// -------- Leaderboard Request --------
+
+// LeaderboardRequest asks for the top of a global leaderboard, see
+// PeriodAllTime and CategoryScore.
+type LeaderboardRequest struct {
+	Period   byte
+	Category byte
+}
+
+func (*LeaderboardRequest) Opcode() byte { return OpcodeLeaderboardRequest }
+
+func (l *LeaderboardRequest) UnmarshalBinary(data []byte) error {
+	if len(data) < 3 {
+		return errors.New("leaderboard request message too short")
+	}
+	if data[0] != OpcodeLeaderboardRequest {
+		return fmt.Errorf("invalid opcode %d for LeaderboardRequest", data[0])
+	}
+	l.Period = data[1]
+	l.Category = data[2]
+	return nil
+}
+
 // -------- Dispatcher --------
 
 // ParseClientMessage parses the binary data into the correct ClientMessage.
@@ -116,6 +181,10 @@ func ParseClientMessage(data []byte) (ClientMessage, error) {
 		msg = &PowerupPurchase{}
 	case OpcodeSkipWait:
 		msg = &SkipWait{}
+	case OpcodeChoiceSubmission:
+		msg = &ChoiceSubmission{}
+	case OpcodeLeaderboardRequest:
+		msg = &LeaderboardRequest{}
 	default:
 		return nil, fmt.Errorf("unknown opcode %d", data[0])
 	}
@@ -6,19 +6,44 @@ type ClientLobbyMessage interface {
 
 type ClientLobbySubmission struct {
 	ClientID ClientId
-	NewScore uint
+	Answer   int32
 }
 
 func (ClientLobbySubmission) clientLobbyMessage() {}
 
-type ClientLobbyStatusEffect struct {
+type ClientLobbyChoiceSubmission struct {
 	ClientID ClientId
-	Powerup  byte
+	Choice   byte
 }
 
-func (ClientLobbyStatusEffect) clientLobbyMessage() {}
+func (ClientLobbyChoiceSubmission) clientLobbyMessage() {}
+
+type ClientLobbyPowerupPurchase struct {
+	ClientID       ClientId
+	PowerupID      byte
+	AffectedPlayer ClientId
+}
+
+func (ClientLobbyPowerupPurchase) clientLobbyMessage() {}
 
 type ClientLobbySkipWait struct {
 }
 
 func (ClientLobbySkipWait) clientLobbyMessage() {}
+
+// ClientLobbyRateLimited asks the lobby to warn a client that its messages
+// are being dropped.
+type ClientLobbyRateLimited struct {
+	ClientID  ClientId
+	Throttled byte
+}
+
+func (ClientLobbyRateLimited) clientLobbyMessage() {}
+
+type ClientLobbyLeaderboardRequest struct {
+	ClientID ClientId
+	Period   byte
+	Category byte
+}
+
+func (ClientLobbyLeaderboardRequest) clientLobbyMessage() {}
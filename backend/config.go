@@ -0,0 +1,386 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"strings"
+	"time"
+)
+
+// Config holds the server's runtime settings. Each setting can be given as a
+// command line flag, an environment variable or an entry in a JSON config
+// file, in decreasing order of precedence.
+//
+// The variable of a flag is its name in upper case with dashes replaced by
+// underscores and prefixed by ARITHMETIC_, e.g. -listen-addr is read from
+// ARITHMETIC_LISTEN_ADDR. The config file is a JSON object keyed by flag
+// names, the same format -print-config writes.
+type Config struct {
+	ListenAddr string
+	// AdminAddr is where pprof and other operator endpoints are served,
+	// nowhere if empty
+	AdminAddr string
+	// AdminToken is the bearer token of the admin API, which is disabled
+	// without one
+	AdminToken string
+
+	FrontendDir     string
+	QuestionPackDir string
+	DataDir         string
+	// Dev serves the frontend from FrontendDir even when one is embedded
+	Dev bool
+
+	ClientsPerLobby     int
+	StartDelay          time.Duration
+	EliminationInterval time.Duration
+	LeaderboardInterval time.Duration
+	AFK                 AFKPolicy
+
+	ChoiceCount          int
+	RecentQuestionWindow int
+
+	// MatchWindow is how far a lobby's average rating may be from a
+	// player's to be matched, growing by MatchWindowGrowth for every second
+	// the lobby waited for players. A lobby that waited MatchOpenAfter takes
+	// players of any rating
+	MatchWindow       float64
+	MatchWindowGrowth float64
+	MatchOpenAfter    time.Duration
+
+	// AllowedOrigins are the origins besides the server's own that may open
+	// websockets, "*" allows any
+	AllowedOrigins      stringList
+	MaxConnections      int
+	MaxConnectionsPerIP int
+
+	RateLimits     RateLimits
+	MaxMessageSize int64
+
+	SendQueueSize int
+	MaxDrainTime  time.Duration
+
+	LogLevel  slog.Level
+	LogFormat string
+	// LogMessages names the message types logged whenever one is sent or
+	// received
+	LogMessages stringList
+}
+
+// stringList is a comma separated list flag.
+type stringList []string
+
+func (l *stringList) String() string { return strings.Join(*l, ",") }
+
+func (l *stringList) Set(s string) error {
+	*l = nil
+	for _, v := range strings.Split(s, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			*l = append(*l, v)
+		}
+	}
+	return nil
+}
+
+// envPrefix is prepended to the environment variable of every flag.
+const envPrefix = "ARITHMETIC_"
+
+func DefaultConfig() Config {
+	return Config{
+		ListenAddr:      "0.0.0.0:8080",
+		AdminAddr:       "127.0.0.1:8081",
+		FrontendDir:     "../frontend/dist",
+		QuestionPackDir: "questionpacks",
+		DataDir:         "data",
+
+		ClientsPerLobby:     40,
+		StartDelay:          time.Minute,
+		EliminationInterval: 30 * time.Second,
+		LeaderboardInterval: 250 * time.Millisecond,
+		AFK: AFKPolicy{
+			WarnAfter:      20 * time.Second,
+			EliminateAfter: 40 * time.Second,
+		},
+
+		ChoiceCount:          4,
+		RecentQuestionWindow: 10,
+
+		MatchWindow:       100,
+		MatchWindowGrowth: 25,
+		MatchOpenAfter:    10 * time.Second,
+
+		// the vite dev server
+		AllowedOrigins:      stringList{"http://localhost:5173", "http://127.0.0.1:5173"},
+		MaxConnections:      10000,
+		MaxConnectionsPerIP: 20,
+
+		RateLimits: RateLimits{
+			Submission: RateLimit{Rate: 10, Burst: 20},
+			Powerup:    RateLimit{Rate: 2, Burst: 5},
+			Message:    RateLimit{Rate: 5, Burst: 10},
+			WarnAfter:  10,
+			KickAfter:  50,
+		},
+		MaxMessageSize: 512,
+
+		SendQueueSize: 64,
+		MaxDrainTime:  5 * time.Minute,
+
+		LogLevel:  slog.LevelInfo,
+		LogFormat: "text",
+	}
+}
+
+// configFlags returns a flag set that stores its values into c.
+func configFlags(c *Config) *flag.FlagSet {
+	fs := flag.NewFlagSet("arithmetic-game", flag.ContinueOnError)
+
+	fs.StringVar(&c.ListenAddr, "listen-addr", c.ListenAddr, "address to listen on")
+	fs.StringVar(&c.AdminAddr, "admin-addr", c.AdminAddr, "address of the admin listener, empty to disable")
+	fs.StringVar(&c.AdminToken, "admin-token", c.AdminToken, "bearer token required by the admin api, which is disabled if empty")
+	fs.StringVar(&c.FrontendDir, "frontend-dir", c.FrontendDir, "directory of the built frontend")
+	fs.StringVar(&c.QuestionPackDir, "question-pack-dir", c.QuestionPackDir, "directory to load question packs from")
+	fs.StringVar(&c.DataDir, "data-dir", c.DataDir, "directory of the player database")
+	fs.BoolVar(&c.Dev, "dev", c.Dev, "serve the frontend from frontend-dir even if one is embedded")
+
+	fs.IntVar(&c.ClientsPerLobby, "clients-per-lobby", c.ClientsPerLobby, "maximum number of players in a lobby")
+	fs.DurationVar(&c.StartDelay, "start-delay", c.StartDelay, "how long a lobby waits for players before starting")
+	fs.DurationVar(&c.EliminationInterval, "elimination-interval", c.EliminationInterval, "time between elimination rounds")
+	fs.DurationVar(&c.LeaderboardInterval, "leaderboard-interval", c.LeaderboardInterval, "how often leaderboard changes are sent")
+	fs.DurationVar(&c.AFK.WarnAfter, "afk-warn-after", c.AFK.WarnAfter, "idle time before a player is warned, 0 to disable")
+	fs.DurationVar(&c.AFK.EliminateAfter, "afk-eliminate-after", c.AFK.EliminateAfter, "idle time before a player is eliminated, 0 to disable")
+
+	fs.IntVar(&c.ChoiceCount, "choice-count", c.ChoiceCount, "number of options of a multiple choice question")
+	fs.IntVar(&c.RecentQuestionWindow, "recent-question-window", c.RecentQuestionWindow, "number of previous questions a new question must differ from")
+
+	fs.Float64Var(&c.MatchWindow, "match-window", c.MatchWindow, "rating difference allowed between a player and a lobby")
+	fs.Float64Var(&c.MatchWindowGrowth, "match-window-growth", c.MatchWindowGrowth, "how much the rating window widens per second a lobby waits for players")
+	fs.DurationVar(&c.MatchOpenAfter, "match-open-after", c.MatchOpenAfter, "wait for players after which a lobby takes players of any rating")
+
+	fs.Var(&c.AllowedOrigins, "allowed-origins", "comma separated origins allowed to connect besides the server's own, * for any")
+	fs.IntVar(&c.MaxConnections, "max-connections", c.MaxConnections, "maximum number of concurrent websocket connections, 0 for no limit")
+	fs.IntVar(&c.MaxConnectionsPerIP, "max-connections-per-ip", c.MaxConnectionsPerIP, "maximum number of concurrent websocket connections from one IP, 0 for no limit")
+
+	fs.Float64Var(&c.RateLimits.Submission.Rate, "submission-rate", c.RateLimits.Submission.Rate, "answers a client may submit per second")
+	fs.IntVar(&c.RateLimits.Submission.Burst, "submission-burst", c.RateLimits.Submission.Burst, "answers a client may submit in a burst")
+	fs.Float64Var(&c.RateLimits.Powerup.Rate, "powerup-rate", c.RateLimits.Powerup.Rate, "powerups a client may buy per second")
+	fs.IntVar(&c.RateLimits.Powerup.Burst, "powerup-burst", c.RateLimits.Powerup.Burst, "powerups a client may buy in a burst")
+	fs.Float64Var(&c.RateLimits.Message.Rate, "message-rate", c.RateLimits.Message.Rate, "other messages a client may send per second")
+	fs.IntVar(&c.RateLimits.Message.Burst, "message-burst", c.RateLimits.Message.Burst, "other messages a client may send in a burst")
+	fs.IntVar(&c.RateLimits.WarnAfter, "rate-limit-warn-after", c.RateLimits.WarnAfter, "dropped messages between warnings to a client")
+	fs.IntVar(&c.RateLimits.KickAfter, "rate-limit-kick-after", c.RateLimits.KickAfter, "dropped messages after which a client is disconnected")
+	fs.Int64Var(&c.MaxMessageSize, "max-message-size", c.MaxMessageSize, "largest websocket message accepted from a client in bytes")
+
+	fs.IntVar(&c.SendQueueSize, "send-queue-size", c.SendQueueSize, "outbound messages buffered per client")
+	fs.DurationVar(&c.MaxDrainTime, "max-drain-time", c.MaxDrainTime, "how long running games may finish during shutdown")
+
+	fs.TextVar(&c.LogLevel, "log-level", c.LogLevel, "minimum level of logged events: debug, info, warn or error")
+	fs.StringVar(&c.LogFormat, "log-format", c.LogFormat, "log output format: text or json")
+	fs.Var(&c.LogMessages, "log-messages", "comma separated message types, e.g. Submission,NewQuestion in any case, logged at debug level whenever one is sent or received, all for every type")
+
+	return fs
+}
+
+// LoadConfig builds the configuration from the defaults, the config file
+// given by -config, environment variables and the flags in args. It also
+// reports whether -print-config was given.
+func LoadConfig(args []string) (Config, bool, error) {
+	var path string
+	var printConfig bool
+
+	// the first pass only finds the config file, the flags are applied
+	// again last so that they take precedence
+	scratch := DefaultConfig()
+	fs := configFlags(&scratch)
+	fs.StringVar(&path, "config", "", "path of a JSON config file")
+	fs.BoolVar(&printConfig, "print-config", false, "print the configuration and exit")
+	if err := fs.Parse(args); err != nil {
+		return Config{}, false, err
+	}
+
+	c := DefaultConfig()
+	fs = configFlags(&c)
+
+	if path != "" {
+		if err := loadConfigFile(fs, path); err != nil {
+			return Config{}, false, fmt.Errorf("config file %s: %w", path, err)
+		}
+	}
+
+	var err error
+	fs.VisitAll(func(f *flag.Flag) {
+		name := envPrefix + strings.ToUpper(strings.ReplaceAll(f.Name, "-", "_"))
+		if v, ok := os.LookupEnv(name); ok && err == nil {
+			if setErr := fs.Set(f.Name, v); setErr != nil {
+				err = fmt.Errorf("%s: %w", name, setErr)
+			}
+		}
+	})
+	if err != nil {
+		return Config{}, false, err
+	}
+
+	// -config and -print-config were handled by the first pass
+	fs.String("config", "", "")
+	fs.Bool("print-config", false, "")
+	fs.SetOutput(io.Discard)
+	if err := fs.Parse(args); err != nil {
+		return Config{}, false, err
+	}
+
+	if err := c.validate(); err != nil {
+		return Config{}, false, err
+	}
+
+	return c, printConfig, nil
+}
+
+// loadConfigFile sets the flags named by the keys of the JSON object in the
+// file at path.
+func loadConfigFile(fs *flag.FlagSet, path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	d := json.NewDecoder(bytes.NewReader(data))
+	d.UseNumber()
+
+	values := map[string]any{}
+	if err := d.Decode(&values); err != nil {
+		return err
+	}
+
+	for name, v := range values {
+		f := fs.Lookup(name)
+		if f == nil {
+			return fmt.Errorf("unknown setting %q", name)
+		}
+		s, err := configValue(f, v)
+		if err != nil {
+			return fmt.Errorf("%s: %w", name, err)
+		}
+		if err := fs.Set(name, s); err != nil {
+			return fmt.Errorf("%s: %w", name, err)
+		}
+	}
+
+	return nil
+}
+
+// configValue returns the flag value of a setting decoded from JSON. Lists
+// are only accepted for list flags and are joined with commas.
+func configValue(f *flag.Flag, v any) (string, error) {
+	_, isList := f.Value.(*stringList)
+
+	switch v := v.(type) {
+	case []any:
+		if !isList {
+			return "", errors.New("not a list setting")
+		}
+		items := make([]string, len(v))
+		for i, item := range v {
+			s, ok := item.(string)
+			if !ok {
+				return "", fmt.Errorf("list item %v is not a string", item)
+			}
+			items[i] = s
+		}
+		return strings.Join(items, ","), nil
+	case map[string]any:
+		return "", errors.New("objects aren't valid values")
+	case nil:
+		return "", errors.New("null isn't a valid value")
+	default:
+		return fmt.Sprint(v), nil
+	}
+}
+
+func (c Config) validate() error {
+	var errs []error
+
+	if c.ListenAddr == "" {
+		errs = append(errs, errors.New("listen-addr must not be empty"))
+	}
+	// player ids are sent as a single byte
+	if c.ClientsPerLobby < 1 || c.ClientsPerLobby > 255 {
+		errs = append(errs, errors.New("clients-per-lobby must be between 1 and 255"))
+	}
+	if c.StartDelay <= 0 {
+		errs = append(errs, errors.New("start-delay must be positive"))
+	}
+	if c.EliminationInterval <= 0 {
+		errs = append(errs, errors.New("elimination-interval must be positive"))
+	}
+	if c.LeaderboardInterval <= 0 {
+		errs = append(errs, errors.New("leaderboard-interval must be positive"))
+	}
+	if c.AFK.WarnAfter < 0 || c.AFK.EliminateAfter < 0 {
+		errs = append(errs, errors.New("afk durations must not be negative"))
+	}
+	if c.AFK.WarnAfter > 0 && c.AFK.EliminateAfter > 0 && c.AFK.WarnAfter >= c.AFK.EliminateAfter {
+		errs = append(errs, errors.New("afk-warn-after must be shorter than afk-eliminate-after"))
+	}
+	if c.ChoiceCount < 3 || c.ChoiceCount > 5 {
+		errs = append(errs, errors.New("choice-count must be between 3 and 5"))
+	}
+	if c.RecentQuestionWindow < 0 {
+		errs = append(errs, errors.New("recent-question-window must not be negative"))
+	}
+	if c.MaxConnections < 0 || c.MaxConnectionsPerIP < 0 {
+		errs = append(errs, errors.New("connection limits must not be negative"))
+	}
+	for _, l := range []RateLimit{c.RateLimits.Submission, c.RateLimits.Powerup, c.RateLimits.Message} {
+		if l.Rate <= 0 || l.Burst < 1 {
+			errs = append(errs, errors.New("message rates must be positive and bursts at least 1"))
+			break
+		}
+	}
+	if c.RateLimits.WarnAfter < 1 || c.RateLimits.KickAfter < 1 {
+		errs = append(errs, errors.New("rate-limit-warn-after and rate-limit-kick-after must be at least 1"))
+	}
+	// a returning player's register message with the longest name must fit
+	if c.MaxMessageSize < maxRegisterSize {
+		errs = append(errs, fmt.Errorf("max-message-size must be at least %d", maxRegisterSize))
+	}
+	if c.MatchWindow < 0 || c.MatchWindowGrowth < 0 || c.MatchOpenAfter < 0 {
+		errs = append(errs, errors.New("matchmaking settings must not be negative"))
+	}
+	if c.SendQueueSize < 1 {
+		errs = append(errs, errors.New("send-queue-size must be at least 1"))
+	}
+	if c.MaxDrainTime < 0 {
+		errs = append(errs, errors.New("max-drain-time must not be negative"))
+	}
+	if c.LogFormat != "text" && c.LogFormat != "json" {
+		errs = append(errs, errors.New("log-format must be text or json"))
+	}
+	for _, name := range c.LogMessages {
+		if !strings.EqualFold(name, "all") && !isMessageName(name) {
+			errs = append(errs, fmt.Errorf("log-messages: unknown message type %q", name))
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// Print writes the configuration as a JSON config file.
+func (c Config) Print(w io.Writer) error {
+	values := map[string]string{}
+	configFlags(&c).VisitAll(func(f *flag.Flag) {
+		values[f.Name] = f.Value.String()
+	})
+	// keep the secret out of logs and terminals
+	if c.AdminToken != "" {
+		values["admin-token"] = "REDACTED"
+	}
+
+	e := json.NewEncoder(w)
+	e.SetIndent("", "  ")
+	return e.Encode(values)
+}
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+func TestLoadConfigFile(t *testing.T) {
+	tests := []struct {
+		json    string
+		ok      bool
+		origins []string
+	}{
+		{`{"allowed-origins": ["http://a", "http://b"]}`, true, []string{"http://a", "http://b"}},
+		{`{"allowed-origins": "http://a,http://b"}`, true, []string{"http://a", "http://b"}},
+		{`{"allowed-origins": [1]}`, false, nil},
+		{`{"clients-per-lobby": [3, 4]}`, false, nil},
+		{`{"clients-per-lobby": {"n": 3}}`, false, nil},
+		{`{"clients-per-lobby": null}`, false, nil},
+		{`{"clients-per-lobby": 3}`, true, nil},
+	}
+
+	for _, tt := range tests {
+		path := filepath.Join(t.TempDir(), "config.json")
+		if err := os.WriteFile(path, []byte(tt.json), 0o644); err != nil {
+			t.Fatal(err)
+		}
+
+		c, _, err := LoadConfig([]string{"-config", path})
+		if (err == nil) != tt.ok {
+			t.Errorf("loading %s: got error %v, want ok %v", tt.json, err, tt.ok)
+			continue
+		}
+		if tt.origins != nil && !slices.Equal(c.AllowedOrigins, tt.origins) {
+			t.Errorf("loading %s: got origins %q, want %q", tt.json, c.AllowedOrigins, tt.origins)
+		}
+	}
+}
@@ -0,0 +1,325 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"slices"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// Expr is a node of an arithmetic expression tree. The same tree renders the
+// question text and evaluates its answer, so the two can't disagree.
+type Expr interface {
+	Eval() (int, error)
+	String() string
+}
+
+// Operator is a binary arithmetic operator, represented by its symbol.
+type Operator rune
+
+const (
+	OpAdd Operator = '+'
+	OpSub Operator = '-'
+	OpMul Operator = '×'
+	OpDiv Operator = '÷'
+	OpPow Operator = '^'
+)
+
+func (o Operator) String() string { return string(o) }
+
+func (o Operator) precedence() int {
+	switch o {
+	case OpAdd, OpSub:
+		return 1
+	case OpMul, OpDiv:
+		return 2
+	case OpPow:
+		return 3
+	default:
+		return 0
+	}
+}
+
+func (o Operator) rightAssociative() bool { return o == OpPow }
+
+func (o Operator) commutative() bool { return o == OpAdd || o == OpMul }
+
+// -------- Num --------
+
+type Num int
+
+func (n Num) Eval() (int, error) { return inRange(int(n)) }
+
+func (n Num) String() string { return strconv.Itoa(int(n)) }
+
+// -------- Binary --------
+
+type BinaryExpr struct {
+	Op          Operator
+	Left, Right Expr
+}
+
+func (b BinaryExpr) Eval() (int, error) {
+	l, err := b.Left.Eval()
+	if err != nil {
+		return 0, err
+	}
+	r, err := b.Right.Eval()
+	if err != nil {
+		return 0, err
+	}
+
+	// operands are within int32, so sums and products can't overflow an
+	// int before their range is checked
+	switch b.Op {
+	case OpAdd:
+		return inRange(l + r)
+	case OpSub:
+		return inRange(l - r)
+	case OpMul:
+		return inRange(l * r)
+	case OpDiv:
+		if r == 0 {
+			return 0, errors.New("division by zero")
+		}
+		if l%r != 0 {
+			return 0, fmt.Errorf("%d ÷ %d is not an integer", l, r)
+		}
+		return inRange(l / r)
+	case OpPow:
+		if r < 0 || r > 64 {
+			return 0, fmt.Errorf("unsupported exponent %d", r)
+		}
+		result := 1
+		for range r {
+			var err error
+			if result, err = inRange(result * l); err != nil {
+				return 0, err
+			}
+		}
+		return result, nil
+	default:
+		return 0, fmt.Errorf("unknown operator %q", rune(b.Op))
+	}
+}
+
+// inRange returns v, or an error if it doesn't fit the int32 answers of the
+// protocol.
+func inRange(v int) (int, error) {
+	if v < math.MinInt32 || v > math.MaxInt32 {
+		return 0, fmt.Errorf("%d is out of range", v)
+	}
+	return v, nil
+}
+
+// String renders the expression with only the parentheses needed to parse it
+// back into the same tree.
+func (b BinaryExpr) String() string {
+	p := b.Op.precedence()
+
+	left := b.Left.String()
+	if lp := exprPrecedence(b.Left); lp < p || (lp == p && b.Op.rightAssociative()) {
+		left = "(" + left + ")"
+	}
+
+	right := b.Right.String()
+	if rp := exprPrecedence(b.Right); rp < p || (rp == p && !b.Op.rightAssociative()) {
+		right = "(" + right + ")"
+	}
+
+	return left + " " + b.Op.String() + " " + right
+}
+
+// exprPrecedence returns how tightly e binds when rendered inside another
+// expression. Negative numbers are parenthesised like a sum.
+func exprPrecedence(e Expr) int {
+	switch e := e.(type) {
+	case BinaryExpr:
+		return e.Op.precedence()
+	case Num:
+		if e < 0 {
+			return 0
+		}
+	case rawExpr:
+		return e.precedence
+	}
+	return OpPow.precedence() + 1
+}
+
+func num(n int) Expr { return Num(n) }
+
+func bin(op Operator, left, right Expr) Expr {
+	return BinaryExpr{Op: op, Left: left, Right: right}
+}
+
+// canonicalExpr renders e with the operands of chained + and × sorted, so
+// that expressions differing only by commutativity render the same.
+func canonicalExpr(e Expr) string {
+	b, ok := e.(BinaryExpr)
+	if !ok {
+		return e.String()
+	}
+
+	if !b.Op.commutative() {
+		return bin(b.Op, canonicalOperand(b.Left), canonicalOperand(b.Right)).String()
+	}
+
+	var operands []string
+	var collect func(Expr)
+	collect = func(e Expr) {
+		if c, ok := e.(BinaryExpr); ok && c.Op == b.Op {
+			collect(c.Left)
+			collect(c.Right)
+			return
+		}
+		s := canonicalExpr(e)
+		if exprPrecedence(e) <= b.Op.precedence() {
+			s = "(" + s + ")"
+		}
+		operands = append(operands, s)
+	}
+	collect(b)
+
+	slices.Sort(operands)
+	return strings.Join(operands, " "+b.Op.String()+" ")
+}
+
+// canonicalOperand wraps the canonical form of an operand so it renders with
+// the parentheses it needs inside its parent expression.
+func canonicalOperand(e Expr) Expr {
+	if _, ok := e.(BinaryExpr); !ok {
+		return e
+	}
+	return rawExpr{canonicalExpr(e), exprPrecedence(e)}
+}
+
+// rawExpr is pre-rendered text standing in for a subexpression.
+type rawExpr struct {
+	text       string
+	precedence int
+}
+
+func (r rawExpr) Eval() (int, error) { return 0, errors.New("raw expression") }
+
+func (r rawExpr) String() string { return r.text }
+
+// -------- Parser --------
+
+// ParseExpr parses an arithmetic expression such as "3 + 4 × (2 - 1)". Both
+// × ÷ and * / are accepted, and a trailing "=" is ignored.
+func ParseExpr(s string) (Expr, error) {
+	s = strings.TrimSpace(s)
+	s = strings.TrimSpace(strings.TrimSuffix(s, "="))
+
+	p := &exprParser{input: []rune(s)}
+	e, err := p.parseBinary(1)
+	if err != nil {
+		return nil, err
+	}
+
+	p.skipSpace()
+	if p.pos < len(p.input) {
+		return nil, fmt.Errorf("unexpected %q at position %d", p.input[p.pos], p.pos)
+	}
+
+	return e, nil
+}
+
+type exprParser struct {
+	input []rune
+	pos   int
+}
+
+func (p *exprParser) skipSpace() {
+	for p.pos < len(p.input) && unicode.IsSpace(p.input[p.pos]) {
+		p.pos++
+	}
+}
+
+// peekOperator returns the operator at the current position, if any.
+func (p *exprParser) peekOperator() (Operator, bool) {
+	p.skipSpace()
+	if p.pos >= len(p.input) {
+		return 0, false
+	}
+
+	switch r := p.input[p.pos]; r {
+	case '+', '-', '×', '÷', '^':
+		return Operator(r), true
+	case '*':
+		return OpMul, true
+	case '/':
+		return OpDiv, true
+	}
+
+	return 0, false
+}
+
+// parseBinary parses operators binding at least as tightly as minPrecedence
+// by precedence climbing.
+func (p *exprParser) parseBinary(minPrecedence int) (Expr, error) {
+	left, err := p.parsePrimary()
+	if err != nil {
+		return nil, err
+	}
+
+	for {
+		op, ok := p.peekOperator()
+		if !ok || op.precedence() < minPrecedence {
+			return left, nil
+		}
+		p.pos++
+
+		next := op.precedence() + 1
+		if op.rightAssociative() {
+			next = op.precedence()
+		}
+
+		right, err := p.parseBinary(next)
+		if err != nil {
+			return nil, err
+		}
+
+		left = bin(op, left, right)
+	}
+}
+
+func (p *exprParser) parsePrimary() (Expr, error) {
+	p.skipSpace()
+	if p.pos >= len(p.input) {
+		return nil, errors.New("unexpected end of expression")
+	}
+
+	switch r := p.input[p.pos]; {
+	case r == '(':
+		p.pos++
+		e, err := p.parseBinary(1)
+		if err != nil {
+			return nil, err
+		}
+		p.skipSpace()
+		if p.pos >= len(p.input) || p.input[p.pos] != ')' {
+			return nil, errors.New("missing closing parenthesis")
+		}
+		p.pos++
+		return e, nil
+
+	case r == '-' || unicode.IsDigit(r):
+		start := p.pos
+		p.pos++
+		for p.pos < len(p.input) && unicode.IsDigit(p.input[p.pos]) {
+			p.pos++
+		}
+		n, err := strconv.Atoi(string(p.input[start:p.pos]))
+		if err != nil {
+			return nil, fmt.Errorf("invalid number %q", string(p.input[start:p.pos]))
+		}
+		return Num(n), nil
+
+	default:
+		return nil, fmt.Errorf("unexpected %q at position %d", r, p.pos)
+	}
+}
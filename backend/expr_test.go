@@ -0,0 +1,32 @@
+package main
+
+import "testing"
+
+func TestEvalRange(t *testing.T) {
+	tests := []struct {
+		expr string
+		ok   bool
+	}{
+		{"2147483647", true},
+		{"2147483648", false},
+		{"2147483647 + 1", false},
+		{"0 - 2147483647 - 1", true},
+		{"0 - 2147483647 - 2", false},
+		{"46340 × 46340", true},
+		{"65536 × 65536", false},
+		{"2 ^ 30", true},
+		{"2 ^ 31", false},
+		{"2 ^ 64", false},
+		{"(0 - 2) ^ 31", true},
+	}
+
+	for _, tt := range tests {
+		e, err := ParseExpr(tt.expr)
+		if err != nil {
+			t.Fatalf("parsing %q: %v", tt.expr, err)
+		}
+		if _, err := e.Eval(); (err == nil) != tt.ok {
+			t.Errorf("evaluating %q: got error %v, want ok %v", tt.expr, err, tt.ok)
+		}
+	}
+}
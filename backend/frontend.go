@@ -0,0 +1,97 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io/fs"
+	"net/http"
+	"os"
+	"path"
+	"strings"
+)
+
+// frontendHandler serves the single page app in fsys, answering paths that
+// don't name a file with index.html so client side routes work.
+type frontendHandler struct {
+	fsys       fs.FS
+	fileServer http.Handler
+
+	// etags of every file, only set for embedded files, which have no
+	// modification time to revalidate against
+	etags map[string]string
+}
+
+// newFrontendHandler serves the embedded frontend, or the frontend in dir
+// when dev is set or none is embedded.
+func newFrontendHandler(dir string, dev bool) (http.Handler, error) {
+	embedded, ok := embeddedFrontend()
+	if dev || !ok {
+		return &frontendHandler{
+			fsys:       os.DirFS(dir),
+			fileServer: http.FileServerFS(os.DirFS(dir)),
+		}, nil
+	}
+
+	etags, err := computeETags(embedded)
+	if err != nil {
+		return nil, err
+	}
+
+	return &frontendHandler{
+		fsys:       embedded,
+		fileServer: http.FileServerFS(embedded),
+		etags:      etags,
+	}, nil
+}
+
+func (h *frontendHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	name := strings.TrimPrefix(path.Clean(r.URL.Path), "/")
+
+	if info, err := fs.Stat(h.fsys, name); name == "" || err != nil || info.IsDir() {
+		name = "index.html"
+		r.URL.Path = "/"
+	}
+
+	// vite puts content hashed files into assets, so they never change
+	if strings.HasPrefix(name, "assets/") {
+		w.Header().Set("Cache-Control", "public, max-age=31536000, immutable")
+	} else {
+		w.Header().Set("Cache-Control", "no-cache")
+	}
+
+	if etag, ok := h.etags[name]; ok {
+		w.Header().Set("ETag", etag)
+	}
+
+	h.fileServer.ServeHTTP(w, r)
+}
+
+// computeETags hashes every file in fsys.
+func computeETags(fsys fs.FS) (map[string]string, error) {
+	etags := map[string]string{}
+
+	err := fs.WalkDir(fsys, ".", func(name string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+
+		data, err := fs.ReadFile(fsys, name)
+		if err != nil {
+			return err
+		}
+
+		sum := sha256.Sum256(data)
+		etags[name] = `"` + hex.EncodeToString(sum[:16]) + `"`
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if _, ok := etags["index.html"]; !ok {
+		return nil, errors.New("embedded frontend has no index.html")
+	}
+
+	return etags, nil
+}
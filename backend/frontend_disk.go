@@ -0,0 +1,11 @@
+//go:build !embed
+
+package main
+
+import "io/fs"
+
+// embeddedFrontend reports that the frontend isn't compiled into the binary.
+// Build with -tags embed to include it.
+func embeddedFrontend() (fs.FS, bool) {
+	return nil, false
+}
@@ -0,0 +1,22 @@
+//go:build embed
+
+package main
+
+import (
+	"embed"
+	"io/fs"
+)
+
+// dist is a copy of the frontend build, see the build-embed make target.
+//
+//go:embed all:dist
+var dist embed.FS
+
+// embeddedFrontend returns the frontend compiled into the binary.
+func embeddedFrontend() (fs.FS, bool) {
+	sub, err := fs.Sub(dist, "dist")
+	if err != nil {
+		return nil, false
+	}
+	return sub, true
+}
@@ -1,54 +1,154 @@
 package main
 
 import (
-	"log"
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math"
 	"net/http"
+	"slices"
+	"strconv"
 	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/gorilla/websocket"
 )
 
 type Hub struct {
-	registerClientQueue chan *Client
+	registerClientQueue chan lobbyRequest
 
-	lobbiesMu sync.Mutex
-	lobbies   []*Lobby
+	lobbiesMu   sync.Mutex
+	lobbies     []*Lobby
+	nextLobbyID int
+
+	// lobbiesWG counts the lobbies that haven't closed yet
+	lobbiesWG sync.WaitGroup
+
+	// draining is set once the server shuts down, no clients are placed
+	// into lobbies after that
+	draining atomic.Bool
+
+	config Config
+
+	upgrader websocket.Upgrader
+	conns    *connLimiter
+	messages *messageLog
+
+	store *Store
+
+	packs map[string]*QuestionPack
 }
 
-const ClientsPerLobby = 40
+// lobbyRequest is a client waiting to be placed into a lobby.
+type lobbyRequest struct {
+	client *Client
+
+	// room is the code of the private lobby to join, empty for public ones
+	room string
+	// pack names the question pack used when room creates a new lobby
+	pack string
+	// lobbyID is the public lobby to join, or -1 for the best one
+	lobbyID int
+
+	// queuedAt is when the client asked to be placed
+	queuedAt time.Time
+}
 
-func NewHub() *Hub {
+func NewHub(config Config, packs map[string]*QuestionPack, store *Store) *Hub {
 	h := &Hub{
-		registerClientQueue: make(chan *Client, ClientsPerLobby*10),
+		registerClientQueue: make(chan lobbyRequest, config.ClientsPerLobby*10),
 		lobbies:             []*Lobby{},
+		config:              config,
+		upgrader: websocket.Upgrader{
+			CheckOrigin: checkOrigin(config.AllowedOrigins),
+		},
+		conns:    newConnLimiter(config.MaxConnections, config.MaxConnectionsPerIP),
+		messages: newMessageLog(config.LogMessages),
+		store:    store,
+		packs:    packs,
 	}
 
 	return h
 }
 
 func (h *Hub) Run() {
-	log.Println("Hub Started")
+	slog.Info("hub started")
 
-	for client := range h.registerClientQueue {
-		log.Println("Client Recieved in Hub")
+	for req := range h.registerClientQueue {
+		h.place(req)
+	}
+}
 
-		r := h.findBestLobby()
+// place puts the client into a lobby.
+func (h *Hub) place(req lobbyRequest) {
+	var r *Lobby
+	switch {
+	case req.room != "":
+		r = h.findPrivateLobby(req.room, req.pack)
+	case req.lobbyID >= 0:
+		r = h.findPublicLobby(req.lobbyID)
+	default:
+		r = h.findBestLobby(req.client.rating, time.Since(req.queuedAt))
+	}
 
-		r.register <- client
+	if r == nil {
+		switch {
+		case h.draining.Load():
+			req.client.log().Info("server shutting down, not placing client")
+		case req.room != "":
+			req.client.log().Info("private lobby already started", "room", req.room)
+		default:
+			req.client.log().Info("lobby not joinable", "lobby", req.lobbyID)
+		}
+		close(req.client.write)
+		return
 	}
-}
 
-func (h *Hub) findBestLobby() *Lobby {
-	log.Println("finding best lobby")
+	select {
+	case r.register <- req.client:
+	case <-r.started:
+		// the lobby started or closed while the client was being placed,
+		// so try again
+		go func() { h.registerClientQueue <- req }()
+	case <-r.done:
+		go func() { h.registerClientQueue <- req }()
+	}
+}
 
+// findBestLobby returns the fullest public lobby whose players' average
+// rating is close to the given rating, creating a new lobby if none is. How
+// close it has to be widens the longer the lobby waited for players, so a
+// lobby nobody else matches still fills up, and a lobby that waited
+// MatchOpenAfter takes anyone. A client that is placed again, because the
+// lobby it was matched to started meanwhile, counts its own wait towards
+// the lobbies'. It returns nil only if the server is shutting down.
+func (h *Hub) findBestLobby(rating float64, waited time.Duration) *Lobby {
 	h.lobbiesMu.Lock()
 	defer h.lobbiesMu.Unlock()
 
+	if h.draining.Load() {
+		return nil
+	}
+
 	maxFill := -1
 	var l *Lobby
 
 	for _, lobby := range h.lobbies {
-		if lobby.open && lobby.clientCount() > maxFill {
+		if !lobby.open.Load() || lobby.private {
+			continue
+		}
+
+		age := max(waited, time.Since(lobby.createdAt))
+		window := h.config.MatchWindow + h.config.MatchWindowGrowth*age.Seconds()
+
+		avg, ok := lobby.averageRating()
+		if ok && age < h.config.MatchOpenAfter && math.Abs(avg-rating) > window {
+			continue
+		}
+
+		if lobby.clientCount() > maxFill {
 			l = lobby
 			maxFill = lobby.clientCount()
 		}
@@ -58,62 +158,260 @@ func (h *Hub) findBestLobby() *Lobby {
 		return l
 	}
 
-	log.Println("New room created")
-
-	l = newLobby(len(h.lobbies), h)
+	l = newLobby(h.nextLobbyID, h, h.config, DefaultQuestionGenerator)
+	h.nextLobbyID++
+	l.logger.Info("lobby created")
 	h.lobbies = append(h.lobbies, l)
+	h.lobbiesWG.Add(1)
 
 	go l.run()
 
 	return l
 }
 
-var upgrader = websocket.Upgrader{
-	CheckOrigin: func(r *http.Request) bool { return true },
+// findPublicLobby returns the public lobby with the given id if it is still
+// waiting for players, or nil.
+func (h *Hub) findPublicLobby(id int) *Lobby {
+	h.lobbiesMu.Lock()
+	defer h.lobbiesMu.Unlock()
+
+	if h.draining.Load() {
+		return nil
+	}
+
+	for _, lobby := range h.lobbies {
+		if lobby.id == id && !lobby.private && lobby.open.Load() {
+			return lobby
+		}
+	}
+	return nil
+}
+
+// findPrivateLobby returns the private lobby with the given code, creating it
+// with the named question pack if it doesn't exist yet. It returns nil if the
+// lobby has already started its game or the server is shutting down.
+func (h *Hub) findPrivateLobby(code string, pack string) *Lobby {
+	h.lobbiesMu.Lock()
+	defer h.lobbiesMu.Unlock()
+
+	if h.draining.Load() {
+		return nil
+	}
+
+	for _, lobby := range h.lobbies {
+		if lobby.private && lobby.code == code {
+			if !lobby.open.Load() {
+				return nil
+			}
+			return lobby
+		}
+	}
+
+	var questions QuestionGenerator = DefaultQuestionGenerator
+	if p, ok := h.packs[pack]; ok {
+		questions = p
+	} else if pack != "" {
+		slog.Warn("unknown question pack, using default questions", "pack", pack)
+		pack = ""
+	}
+
+	l := newLobby(h.nextLobbyID, h, h.config, questions)
+	h.nextLobbyID++
+	l.private = true
+	l.code = code
+	l.pack = pack
+	l.logger.Info("private lobby created", "room", code, "pack", pack)
+	h.lobbies = append(h.lobbies, l)
+	h.lobbiesWG.Add(1)
+
+	go l.run()
+
+	return l
 }
 
 func (h *Hub) ServeWs(w http.ResponseWriter, r *http.Request) {
-	conn, err := upgrader.Upgrade(w, r, nil)
+	if h.draining.Load() {
+		http.Error(w, "server shutting down", http.StatusServiceUnavailable)
+		return
+	}
+
+	lobbyID := -1
+	if s := r.URL.Query().Get("lobby"); s != "" {
+		id, err := strconv.Atoi(s)
+		if err != nil || id < 0 {
+			http.Error(w, "invalid lobby id", http.StatusBadRequest)
+			return
+		}
+		lobbyID = id
+	}
+
+	ip := remoteIP(r)
+	admitErr := h.conns.acquire(ip)
+	if errors.Is(admitErr, errTooManyFromIP) {
+		logRejection(r, admitErr)
+		http.Error(w, admitErr.Error(), http.StatusTooManyRequests)
+		return
+	}
+
+	// the upgrader answers failed upgrades itself
+	conn, err := h.upgrader.Upgrade(w, r, nil)
 	if err != nil {
-		w.WriteHeader(http.StatusUpgradeRequired)
-		log.Printf("upgrading error: %s\n", err)
+		if admitErr == nil {
+			h.conns.release(ip)
+		}
+		slog.Warn("error upgrading connection", "remote_ip", ip, "err", err)
+		return
+	}
+
+	if admitErr != nil {
+		// a full server tells the client why before hanging up
+		logRejection(r, admitErr)
+		h.rejectFull(conn)
 		return
 	}
 
-	log.Println("New WS Connection")
+	logger := slog.With("remote_ip", ip)
+	logger.Debug("new websocket connection")
+
+	conn.SetReadLimit(h.config.MaxMessageSize)
 
 	queryParams := r.URL.Query()
 
-	name := queryParams.Get("name")
+	multipleChoice := queryParams.Get("mode") == "choice"
+	room := queryParams.Get("room")
+	pack := queryParams.Get("pack")
 
-	c := &Client{
-		name: name,
-		conn: conn,
+	release := sync.OnceFunc(func() { h.conns.release(ip) })
 
-		write: make(chan ServerMessage),
+	// accounts are only created for clients that register, so merely
+	// connecting doesn't grow the store
+	register, err := readRegister(conn)
+	if err != nil {
+		logger.Warn("error reading register message", "err", err)
+		conn.Close()
+		release()
+		return
 	}
 
-	_, _, err = conn.ReadMessage()
+	// the name comes from the register message, whose format caps its length
+	name := register.Name
+
+	account, token, err := h.account(register.PlayerID, register.Token, name)
 	if err != nil {
-		c.log("error waiting for reading message: %+v", err)
+		logger.Error("error creating account", "err", err)
 		conn.Close()
+		release()
 		return
 	}
+	logger = logger.With("player_id", account.ID)
 
-	hubGreetingMessage, _ := HubGreeting{}.MarshalBinary()
+	c := &Client{
+		name:           name,
+		playerID:       account.ID,
+		conn:           conn,
+		config:         &h.config,
+		multipleChoice: multipleChoice,
+		rating:         account.Rating,
+		achievements:   hasAchievements(account),
+
+		write: make(chan ServerMessage, h.config.SendQueueSize),
+
+		release:  release,
+		messages: h.messages,
+	}
+	c.logger.Store(logger)
+
+	hubGreetingMessage, _ := HubGreeting{Status: HubStatusOK}.MarshalBinary()
 	err = conn.WriteMessage(websocket.BinaryMessage, hubGreetingMessage)
 
 	if err != nil {
-		c.log("error sending hub greeting: %+v", err)
+		c.log().Warn("error sending hub greeting", "err", err)
 		conn.Close()
+		c.release()
 		return
 	}
-
-	log.Println("Sent Hub Greeting")
+	metrics.sent[OpcodeHubGreeting].Add(1)
+
+	if token != "" {
+		created, _ := AccountCreated{PlayerID: account.ID, Token: token}.MarshalBinary()
+		if err := conn.WriteMessage(websocket.BinaryMessage, created); err != nil {
+			c.log().Warn("error sending account", "err", err)
+			conn.Close()
+			c.release()
+			return
+		}
+		metrics.sent[OpcodeAccountCreated].Add(1)
+	}
 
 	go c.writePump()
 
-	h.registerClientQueue <- c
+	h.registerClientQueue <- lobbyRequest{
+		client:   c,
+		room:     room,
+		pack:     pack,
+		lobbyID:  lobbyID,
+		queuedAt: time.Now(),
+	}
+}
+
+// readRegister reads the register message every client starts with. A
+// client that stays silent is dropped, so it doesn't hold a connection slot.
+func readRegister(conn *websocket.Conn) (*Register, error) {
+	conn.SetReadDeadline(time.Now().Add(pongWait))
+	_, data, err := conn.ReadMessage()
+	if err != nil {
+		return nil, err
+	}
+
+	msg, err := ParseClientMessage(data)
+	if err != nil {
+		return nil, err
+	}
+	register, ok := msg.(*Register)
+	if !ok {
+		return nil, fmt.Errorf("expected register message, got opcode %d", msg.Opcode())
+	}
+	return register, nil
+}
+
+// account returns the account the client logged in to with its player id
+// and token. Clients without valid credentials get a new account, whose
+// token is returned as well.
+func (h *Hub) account(id, token, name string) (Account, string, error) {
+	if id != "" {
+		if a, ok := h.store.Authenticate(id, token, name); ok {
+			return a, "", nil
+		}
+		slog.Info("invalid credentials, creating a new account", "player_id", id)
+	}
+
+	return h.store.CreateAccount(name)
+}
+
+// rejectFull greets conn with HubStatusServerFull and closes it.
+func (h *Hub) rejectFull(conn *websocket.Conn) {
+	defer conn.Close()
+
+	greeting, _ := HubGreeting{Status: HubStatusServerFull}.MarshalBinary()
+	conn.SetWriteDeadline(time.Now().Add(writeWait))
+	if err := conn.WriteMessage(websocket.BinaryMessage, greeting); err != nil {
+		return
+	}
+	conn.WriteMessage(websocket.CloseMessage,
+		websocket.FormatCloseMessage(websocket.CloseTryAgainLater, "server full"))
+}
+
+// lobby returns the running lobby with the given id, or nil.
+func (h *Hub) lobby(id int) *Lobby {
+	h.lobbiesMu.Lock()
+	defer h.lobbiesMu.Unlock()
+
+	for _, l := range h.lobbies {
+		if l.id == id {
+			return l
+		}
+	}
+	return nil
 }
 
 func (h *Hub) unregisterLobby(l *Lobby) {
@@ -124,7 +422,51 @@ func (h *Hub) unregisterLobby(l *Lobby) {
 		if lobby == l {
 			h.lobbies[i] = h.lobbies[len(h.lobbies)-1]
 			h.lobbies = h.lobbies[:len(h.lobbies)-1]
+			h.lobbiesWG.Done()
 			return
 		}
 	}
 }
+
+// Drain stops placing clients into lobbies and tells every lobby that the
+// server is shutting down, letting running games finish. Once ctx is done
+// the remaining lobbies are closed. Drain returns when all lobbies closed.
+func (h *Hub) Drain(ctx context.Context) {
+	h.lobbiesMu.Lock()
+	h.draining.Store(true)
+	lobbies := slices.Clone(h.lobbies)
+	h.lobbiesMu.Unlock()
+
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		deadline = time.Now()
+	}
+
+	for _, l := range lobbies {
+		l.notify(HubLobbyShutdown{Deadline: deadline})
+	}
+
+	drained := make(chan struct{})
+	go func() {
+		h.lobbiesWG.Wait()
+		close(drained)
+	}()
+
+	select {
+	case <-drained:
+		return
+	case <-ctx.Done():
+	}
+
+	h.lobbiesMu.Lock()
+	lobbies = slices.Clone(h.lobbies)
+	h.lobbiesMu.Unlock()
+
+	slog.Warn("drain time over, closing lobbies", "lobbies", len(lobbies))
+
+	for _, l := range lobbies {
+		l.notify(HubLobbyClose{})
+	}
+
+	<-drained
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"log/slog"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestMain(m *testing.M) {
+	flag.Parse()
+
+	// the server's logs are only interesting when looking at a failure
+	if !testing.Verbose() {
+		slog.SetDefault(slog.New(slog.DiscardHandler))
+	}
+	os.Exit(m.Run())
+}
+
+// newTestHub returns a running hub storing its data in a temporary
+// directory. Its lobbies are drained when the test ends.
+func newTestHub(t *testing.T, config Config) *Hub {
+	t.Helper()
+
+	store, err := OpenStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	h := NewHub(config, nil, store)
+	go h.Run()
+
+	t.Cleanup(func() {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		h.Drain(ctx)
+		store.Close()
+	})
+
+	return h
+}
+
+func TestFindBestLobbyByRating(t *testing.T) {
+	config := DefaultConfig()
+	config.MatchWindow = 100
+	config.MatchWindowGrowth = 0
+	config.MatchOpenAfter = 10 * time.Second
+	h := newTestHub(t, config)
+
+	first := h.findBestLobby(1500, 0)
+	// pretend a player rated 1500 waits in the lobby
+	first.ratingSum.Store(1500)
+	first.activeClientCount.Store(1)
+
+	if l := h.findBestLobby(1550, 0); l != first {
+		t.Errorf("player within the window got lobby %v, want %d", l, first.id)
+	}
+
+	far := h.findBestLobby(2400, 0)
+	if far == nil || far == first {
+		t.Fatalf("player outside the window got lobby %v, want a new one", far)
+	}
+	far.ratingSum.Store(2400)
+	far.activeClientCount.Store(1)
+
+	if l := h.findBestLobby(1450, 0); l != first {
+		t.Errorf("player close to the first lobby got lobby %d, want %d", l.id, first.id)
+	}
+	if l := h.findBestLobby(2000, config.MatchOpenAfter); l != first && l != far {
+		t.Errorf("player that waited MatchOpenAfter got a new lobby %d", l.id)
+	}
+
+	// lobbies open up to any rating once they waited long enough
+	first.createdAt = time.Now().Add(-config.MatchOpenAfter)
+	if l := h.findBestLobby(2000, 0); l != first {
+		t.Errorf("player far from every lobby got lobby %d, want the open lobby %d", l.id, first.id)
+	}
+}
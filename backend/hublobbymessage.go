@@ -0,0 +1,54 @@
+package main
+
+import (
+	"errors"
+	"time"
+)
+
+// HubLobbyMessage is implemented by all hub->lobby messages.
+type HubLobbyMessage interface {
+	hubLobbyMessage()
+}
+
+// HubLobbyShutdown tells the lobby the server shuts down at Deadline.
+type HubLobbyShutdown struct {
+	Deadline time.Time
+}
+
+func (HubLobbyShutdown) hubLobbyMessage() {}
+
+// HubLobbyClose closes the lobby immediately.
+type HubLobbyClose struct {
+}
+
+func (HubLobbyClose) hubLobbyMessage() {}
+
+// HubLobbyStart starts the game without waiting for more players.
+type HubLobbyStart struct {
+}
+
+func (HubLobbyStart) hubLobbyMessage() {}
+
+// HubLobbyKick disconnects a client, replying with nil or errClientNotFound.
+type HubLobbyKick struct {
+	ClientID ClientId
+	Reply    chan error
+}
+
+func (HubLobbyKick) hubLobbyMessage() {}
+
+// HubLobbyAnnounce shows a message to every client of the lobby.
+type HubLobbyAnnounce struct {
+	Text string
+}
+
+func (HubLobbyAnnounce) hubLobbyMessage() {}
+
+// HubLobbyInspect asks the lobby for the details of its players.
+type HubLobbyInspect struct {
+	Reply chan LobbyDetails
+}
+
+func (HubLobbyInspect) hubLobbyMessage() {}
+
+var errClientNotFound = errors.New("client not found")
@@ -0,0 +1,279 @@
+package main
+
+import (
+	"cmp"
+	"errors"
+	"math"
+	"net/http"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Leaderboard periods. Daily boards reset at midnight UTC and weekly boards
+// on Monday at midnight UTC.
+const (
+	PeriodAllTime byte = iota
+	PeriodWeekly
+	PeriodDaily
+	periodCount
+)
+
+// Leaderboard categories.
+const (
+	// CategoryScore ranks players by their highest score in a single game
+	CategoryScore byte = iota
+	// CategoryCorrectAnswers ranks players by their correct answers over
+	// all games of the period
+	CategoryCorrectAnswers
+	// CategoryRating ranks players by the highest rating they reached
+	CategoryRating
+	categoryCount
+)
+
+var periodNames = [periodCount]string{
+	PeriodAllTime: "all-time",
+	PeriodWeekly:  "weekly",
+	PeriodDaily:   "daily",
+}
+
+var categoryNames = [categoryCount]string{
+	CategoryScore:          "score",
+	CategoryCorrectAnswers: "correct-answers",
+	CategoryRating:         "rating",
+}
+
+const (
+	defaultLeaderboardLimit = 20
+	maxLeaderboardLimit     = 100
+)
+
+// Leaderboard is the ranking of one category over one period.
+type Leaderboard struct {
+	Period   string `json:"period"`
+	Category string `json:"category"`
+	// Start and ResetsAt bound the period, both zero for the all-time boards
+	Start    time.Time         `json:"start,omitzero"`
+	ResetsAt time.Time         `json:"resetsAt,omitzero"`
+	Entries  []LeaderboardRank `json:"entries"`
+}
+
+// LeaderboardRank is a player's place on a leaderboard. Players with the same
+// value share a rank.
+type LeaderboardRank struct {
+	Rank     int    `json:"rank"`
+	PlayerID string `json:"playerId"`
+	Name     string `json:"name"`
+	Value    int64  `json:"value"`
+}
+
+// leaderboards aggregates the recorded matches of every period. A period's
+// totals are dropped once it is over, since its matches can't count towards
+// the current one.
+type leaderboards struct {
+	mu      sync.Mutex
+	periods [periodCount]periodTotals
+}
+
+type periodTotals struct {
+	start   time.Time
+	players map[string]*playerTotals
+	// rankings caches the ranking of each category until the next match is
+	// added
+	rankings [categoryCount]*ranking
+}
+
+// ranking is a sorted ranking along with the index of each player in it.
+type ranking struct {
+	ranks []LeaderboardRank
+	index map[string]int
+}
+
+type playerTotals struct {
+	name           string
+	bestScore      uint
+	correctAnswers uint
+	bestRating     float64
+}
+
+func newLeaderboards() *leaderboards {
+	b := &leaderboards{}
+	now := time.Now()
+	for p := range b.periods {
+		b.periods[p] = periodTotals{
+			start:   periodStart(byte(p), now),
+			players: map[string]*playerTotals{},
+		}
+	}
+	return b
+}
+
+// periodStart returns when the period containing t started, the zero time
+// for all time.
+func periodStart(period byte, t time.Time) time.Time {
+	t = t.UTC()
+	day := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+
+	switch period {
+	case PeriodDaily:
+		return day
+	case PeriodWeekly:
+		// weeks start on Monday
+		return day.AddDate(0, 0, -(int(day.Weekday())+6)%7)
+	default:
+		return time.Time{}
+	}
+}
+
+// periodEnd returns when the period starting at start ends, the zero time for
+// all time.
+func periodEnd(period byte, start time.Time) time.Time {
+	switch period {
+	case PeriodDaily:
+		return start.AddDate(0, 0, 1)
+	case PeriodWeekly:
+		return start.AddDate(0, 0, 7)
+	default:
+		return time.Time{}
+	}
+}
+
+// roll resets the totals and rankings of the periods that ended before now.
+// It must be called with mu held.
+func (b *leaderboards) roll(now time.Time) {
+	for p := range b.periods {
+		start := periodStart(byte(p), now)
+		if !start.Equal(b.periods[p].start) {
+			b.periods[p] = periodTotals{start: start, players: map[string]*playerTotals{}}
+		}
+	}
+}
+
+// add counts a finished match towards the periods it ended in.
+func (b *leaderboards) add(m Match) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.roll(time.Now())
+
+	for p := range b.periods {
+		totals := &b.periods[p]
+		if m.Ended.Before(totals.start) {
+			continue
+		}
+
+		totals.rankings = [categoryCount]*ranking{}
+		for _, mp := range m.Players {
+			t, ok := totals.players[mp.PlayerID]
+			if !ok {
+				t = &playerTotals{}
+				totals.players[mp.PlayerID] = t
+			}
+
+			// matches are added in the order they ended, so this is the
+			// latest name
+			t.name = mp.Name
+			t.bestScore = max(t.bestScore, mp.Score)
+			t.correctAnswers += mp.CorrectAnswers
+			t.bestRating = max(t.bestRating, mp.Rating+mp.RatingDelta)
+		}
+	}
+}
+
+// ranking returns the ranking of a category in a period along with when the
+// period started and ends. The ranking is shared and must not be modified.
+func (b *leaderboards) ranking(period, category byte) (*ranking, time.Time, time.Time) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.roll(time.Now())
+	totals := &b.periods[period]
+	end := periodEnd(period, totals.start)
+
+	if r := totals.rankings[category]; r != nil {
+		return r, totals.start, end
+	}
+
+	ranks := make([]LeaderboardRank, 0, len(totals.players))
+	for id, t := range totals.players {
+		var v int64
+		switch category {
+		case CategoryScore:
+			v = int64(t.bestScore)
+		case CategoryCorrectAnswers:
+			v = int64(t.correctAnswers)
+		case CategoryRating:
+			v = int64(math.Round(t.bestRating))
+		}
+		ranks = append(ranks, LeaderboardRank{PlayerID: id, Name: t.name, Value: v})
+	}
+
+	slices.SortFunc(ranks, func(a, b LeaderboardRank) int {
+		return cmp.Or(cmp.Compare(b.Value, a.Value), cmp.Compare(a.PlayerID, b.PlayerID))
+	})
+	index := make(map[string]int, len(ranks))
+	for i := range ranks {
+		ranks[i].Rank = i + 1
+		if i > 0 && ranks[i].Value == ranks[i-1].Value {
+			ranks[i].Rank = ranks[i-1].Rank
+		}
+		index[ranks[i].PlayerID] = i
+	}
+
+	r := &ranking{ranks: ranks, index: index}
+	totals.rankings[category] = r
+	return r, totals.start, end
+}
+
+// Leaderboard returns the top n of a category in a period.
+func (s *Store) Leaderboard(period, category byte, n int) Leaderboard {
+	r, start, end := s.leaderboards.ranking(period, category)
+
+	return Leaderboard{
+		Period:   periodNames[period],
+		Category: categoryNames[category],
+		Start:    start,
+		ResetsAt: end,
+		Entries:  slices.Clone(r.ranks[:min(n, len(r.ranks))]),
+	}
+}
+
+// LeaderboardRank returns a player's rank in a category in a period,
+// reporting false if the player hasn't played in the period.
+func (s *Store) LeaderboardRank(period, category byte, playerID string) (LeaderboardRank, bool) {
+	r, _, _ := s.leaderboards.ranking(period, category)
+
+	i, ok := r.index[playerID]
+	if !ok {
+		return LeaderboardRank{}, false
+	}
+	return r.ranks[i], true
+}
+
+// ServeLeaderboard returns a leaderboard as JSON. The limit query parameter
+// sets how many entries.
+func (s *Store) ServeLeaderboard(w http.ResponseWriter, r *http.Request) {
+	period := slices.Index(periodNames[:], r.PathValue("period"))
+	if period < 0 {
+		writeError(w, http.StatusNotFound, errors.New("unknown period"))
+		return
+	}
+	category := slices.Index(categoryNames[:], r.PathValue("category"))
+	if category < 0 {
+		writeError(w, http.StatusNotFound, errors.New("unknown category"))
+		return
+	}
+
+	limit := defaultLeaderboardLimit
+	if v := r.URL.Query().Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 {
+			writeError(w, http.StatusBadRequest, errors.New("invalid limit"))
+			return
+		}
+		limit = min(n, maxLeaderboardLimit)
+	}
+
+	writeJSON(w, http.StatusOK, s.Leaderboard(byte(period), byte(category), limit))
+}
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestLeaderboardCache(t *testing.T) {
+	s := &Store{leaderboards: newLeaderboards()}
+
+	s.leaderboards.add(Match{Ended: time.Now(), Players: []MatchPlayer{
+		{PlayerID: "a", Name: "A", Score: 10},
+		{PlayerID: "b", Name: "B", Score: 30},
+	}})
+	if r, ok := s.LeaderboardRank(PeriodDaily, CategoryScore, "a"); !ok || r.Rank != 2 {
+		t.Errorf("rank of a is %+v, %v, want 2", r, ok)
+	}
+
+	// adding a match must not serve the cached ranking
+	s.leaderboards.add(Match{Ended: time.Now(), Players: []MatchPlayer{
+		{PlayerID: "a", Name: "A", Score: 50},
+	}})
+	if r, ok := s.LeaderboardRank(PeriodDaily, CategoryScore, "a"); !ok || r.Rank != 1 {
+		t.Errorf("rank of a after a better score is %+v, %v, want 1", r, ok)
+	}
+	board := s.Leaderboard(PeriodDaily, CategoryScore, 1)
+	if len(board.Entries) != 1 || board.Entries[0].PlayerID != "a" {
+		t.Errorf("top of the board is %+v, want a", board.Entries)
+	}
+
+	// rolling over to the next day must drop the cached ranking
+	s.leaderboards.mu.Lock()
+	s.leaderboards.periods[PeriodDaily].start = s.leaderboards.periods[PeriodDaily].start.AddDate(0, 0, -1)
+	s.leaderboards.mu.Unlock()
+	if r, ok := s.LeaderboardRank(PeriodDaily, CategoryScore, "a"); ok {
+		t.Errorf("a still ranked %+v on a new day", r)
+	}
+	if r, ok := s.LeaderboardRank(PeriodAllTime, CategoryScore, "b"); !ok || r.Rank != 2 {
+		t.Errorf("all-time rank of b is %+v, %v, want 2", r, ok)
+	}
+}
@@ -1,370 +1,900 @@
 package main
 
 import (
-	"fmt"
-	"log"
+	"cmp"
+	"log/slog"
+	"math"
 	"math/rand/v2"
-	"sync"
+	"slices"
 	"sync/atomic"
 	"time"
 )
 
 type ClientId = int
 
+// AFKPolicy decides what happens to players that stop submitting answers
+// during a game. A zero duration disables that step.
+type AFKPolicy struct {
+	WarnAfter      time.Duration
+	EliminateAfter time.Duration
+}
+
+// idleCheckInterval is how often players are checked for being idle.
+const idleCheckInterval = time.Second
+
+// Lobby runs a single game. The lobby's state, including the game state of
+// its clients, is owned by the goroutine executing run. Other goroutines only
+// talk to it over its channels or through the atomics below.
 type Lobby struct {
 	id         int
 	register   chan *Client
 	unregister chan *Client
 
 	lobbyRead chan ClientLobbyMessage
-
-	clientsMu sync.Mutex
+	hubRead   chan HubLobbyMessage
 
 	// contains all clients even those disconnected
-	clients map[ClientId]*Client
+	clients      map[ClientId]*Client
+	nextClientID ClientId
 
 	activeClientCount atomic.Int32
 
+	// ratingSum is the sum of the ratings of the players waiting for the
+	// game to start, rounded to integers
+	ratingSum atomic.Int64
+
+	// standings holds the leaderboard entries last sent to the clients
+	standings     map[ClientId]LeaderboardEntry
+	scoresChanged bool
+
 	hub *Hub
 
-	done chan struct{}
+	// started is closed when the game starts and done when the lobby closes
+	started chan struct{}
+	done    chan struct{}
+
+	open atomic.Bool
+
+	// private lobbies are only joined by their code, never by matchmaking
+	private bool
+	code    string
 
-	open bool
-	closed atomic.Bool
+	questions QuestionGenerator
+	// pack names the question pack, empty for the default questions
+	pack string
+
+	// rng generates the lobby's questions from seed, which is recorded with
+	// the match
+	seed uint64
+	rng  *rand.Rand
+
+	config Config
+	logger *slog.Logger
+
+	createdAt time.Time
+	// startsAt is when the game starts unless the lobby fills up or a
+	// player skips the wait before
+	startsAt time.Time
+	// startedAt is owned by the lobby like the game state
+	startedAt time.Time
 }
 
-func newLobby(id int, hub *Hub) *Lobby {
+func newLobby(id int, hub *Hub, config Config, questions QuestionGenerator) *Lobby {
+	seed := rand.Uint64()
+
 	l := &Lobby{
 		id:         id,
 		register:   make(chan *Client),
 		unregister: make(chan *Client),
 
 		lobbyRead: make(chan ClientLobbyMessage),
+		hubRead:   make(chan HubLobbyMessage),
 
-		clients: make(map[ClientId]*Client),
+		clients:   make(map[ClientId]*Client),
+		standings: make(map[ClientId]LeaderboardEntry),
 
 		hub: hub,
 
-		done: make(chan struct{}),
+		started: make(chan struct{}),
+		done:    make(chan struct{}),
+
+		questions: questions,
+		seed:      seed,
+		rng:       rand.New(rand.NewPCG(seed, seed)),
+		logger:    slog.With("lobby_id", id),
+
+		config: config,
+
+		createdAt: time.Now(),
+		startsAt:  time.Now().Add(config.StartDelay),
 	}
 
+	l.open.Store(true)
+
 	return l
 }
 
 func (l *Lobby) clientCount() int {
-	return len(l.clients)
+	return int(l.activeClientCount.Load())
+}
+
+// averageRating returns the average rating of the players waiting in the
+// lobby, reporting false if there are none.
+func (l *Lobby) averageRating() (float64, bool) {
+	n := l.activeClientCount.Load()
+	if n <= 0 {
+		return 0, false
+	}
+	return float64(l.ratingSum.Load()) / float64(n), true
 }
 
 func (l *Lobby) run() {
-	l.log("running")
+	defer l.close()
+
+	l.logger.Debug("waiting for players")
 
-	l.open = true
-	l.closed.Store(false)
+	if !l.waitForPlayers() {
+		return
+	}
 
-	startGameTimer := time.NewTimer(time.Minute)
+	l.logger.Info("starting game", "players", l.activeClientCount.Load())
 
-	clientId := 0
+	l.startGame()
+	l.play()
+	l.finishGame()
+}
 
-	l.log("lobby started, waiting for players")
+// waitForPlayers registers clients until the start timer fires, a client
+// skips the wait or the lobby is full. It reports false if every client left
+// before that.
+func (l *Lobby) waitForPlayers() bool {
+	startGameTimer := time.NewTimer(time.Until(l.startsAt))
+	defer startGameTimer.Stop()
 
-startGameLoop:
 	for {
 		select {
 		case client := <-l.register:
-			client.id = clientId
-			clientId++
-			l.registerClient(client)
+			if full := l.registerClient(client); full {
+				return true
+			}
 
 		case client := <-l.unregister:
 			l.unregisterClient(client)
+			if l.activeClientCount.Load() == 0 {
+				l.logger.Info("all clients left")
+				return false
+			}
 
 		case <-startGameTimer.C:
-			break startGameLoop
-
-		case <-l.done:
-			l.close()
-			return
+			return true
 
 		case msg := <-l.lobbyRead:
-			_, ok := msg.(ClientLobbySkipWait)
-			if ok {
-				break startGameLoop
+			switch msg := msg.(type) {
+			case ClientLobbySkipWait:
+				return true
+			case ClientLobbyRateLimited, ClientLobbyLeaderboardRequest:
+				l.handleMessage(msg)
+			}
+
+		case msg := <-l.hubRead:
+			if _, ok := msg.(HubLobbyStart); ok {
+				l.logger.Info("started by admin")
+				return true
+			}
+			if !l.handleHubMessage(msg) {
+				return false
 			}
 		}
 	}
+}
 
-	l.open = false
-
-	l.log("wait over, starting game")
+func (l *Lobby) startGame() {
+	l.open.Store(false)
+	close(l.started)
+	l.startedAt = time.Now()
+	metrics.gamesStarted.Add(1)
 
 	for _, client := range l.clients {
+		if client.disconnected {
+			continue
+		}
+
 		client.difficulty = 1
-		client.playing.Store(true)
+		client.playing = true
+		client.lastSubmission = time.Now()
 
 		client.scoreMult = 1.0
 		client.coinMult = 1.0
 
-		question, expectedResult := GenerateQuestion(client.difficulty)
+		client.send(StartGame{})
+		client.nextQuestion(client.difficulty)
+	}
+}
 
-		client.expectedResult = expectedResult
+// play runs the game until every client has been eliminated or left.
+func (l *Lobby) play() {
+	eliminationTicker := time.NewTicker(l.config.EliminationInterval)
+	defer eliminationTicker.Stop()
 
-		client.write <- StartGame{}
-		client.write <- NewQuestion{
-			Difficulty: byte(client.difficulty),
-			Question:   question,
-		}
-	}
+	leaderboardTicker := time.NewTicker(l.config.LeaderboardInterval)
+	defer leaderboardTicker.Stop()
 
-	go l.eliminationHandler()
+	idleTicker := time.NewTicker(idleCheckInterval)
+	defer idleTicker.Stop()
 
-	for {
+	for l.activeClientCount.Load() > 0 {
 		select {
 		case msg := <-l.lobbyRead:
-			switch msg := msg.(type) {
+			l.handleMessage(msg)
 
-			case ClientLobbySubmission:
-				l.broadcast(OpponentScoreChanged{
-					PlayerID: byte(msg.ClientID),
-					NewScore: uint32(msg.NewScore),
-				})
+		case c := <-l.unregister:
+			l.unregisterClient(c)
+
+		case <-eliminationTicker.C:
+			l.eliminate()
 
-			case ClientLobbyStatusEffect:
-				go l.handleLobbyStatusEffect(msg)
+		case <-leaderboardTicker.C:
+			l.sendLeaderboard()
 
+		case <-idleTicker.C:
+			l.checkIdle()
+
+		case msg := <-l.hubRead:
+			if !l.handleHubMessage(msg) {
+				return
 			}
+		}
+	}
 
-		case c := <-l.unregister:
-			l.unregisterClient(c)
+	l.logger.Info("game over")
+}
 
-		case <-l.done:
-			l.close()
-			return
+// handleHubMessage reports whether the lobby keeps running.
+func (l *Lobby) handleHubMessage(msg HubLobbyMessage) bool {
+	switch msg := msg.(type) {
+
+	case HubLobbyShutdown:
+		secondsLeft := time.Until(msg.Deadline).Round(time.Second).Seconds()
+		l.broadcastAll(ServerShuttingDown{
+			SecondsLeft: uint16(max(secondsLeft, 0)),
+		})
 
+		// games that haven't started yet are cut short right away
+		if !l.hasStarted() {
+			l.logger.Info("server shutting down before the game started")
+			return false
 		}
+		l.logger.Info("server shutting down, finishing game")
+
+	case HubLobbyClose:
+		l.logger.Info("closed by hub")
+		return false
+
+	case HubLobbyKick:
+		c, ok := l.clients[msg.ClientID]
+		if !ok || c.disconnected {
+			msg.Reply <- errClientNotFound
+			break
+		}
+
+		c.log().Info("kicked by admin")
+		l.unregisterClient(c)
+		msg.Reply <- nil
+
+		// a lobby still waiting for players closes once the last one is gone
+		return l.hasStarted() || l.activeClientCount.Load() > 0
+
+	case HubLobbyAnnounce:
+		l.broadcastAll(Announcement{Text: msg.Text})
+
+	case HubLobbyInspect:
+		msg.Reply <- l.details()
 	}
-}
 
-func (l *Lobby) registerClient(c *Client) bool {
-	l.clientsMu.Lock()
-	defer l.clientsMu.Unlock()
+	return true
+}
 
-	c.closed.Store(false)
+// finishGame places the players still in the game when it was cut short by
+// their scores, updates everyone's rating and sends them the results.
+func (l *Lobby) finishGame() {
+	var players, remaining []*Client
+	for _, c := range l.clients {
+		if c.playing || c.place > 0 {
+			players = append(players, c)
+		}
+		if c.playing {
+			remaining = append(remaining, c)
+		}
+	}
 
-	l.broadcast(NewRegisteredPlayer{
-		Player{ID: byte(c.id), Name: c.name}})
+	slices.SortFunc(remaining, func(a, b *Client) int {
+		return cmp.Compare(b.score, a.score)
+	})
+	for i, c := range remaining {
+		c.place = i + 1
+		c.playing = false
+	}
 
-	c.unregister = l.unregister
-	c.read = l.lobbyRead
+	slices.SortFunc(players, func(a, b *Client) int {
+		return cmp.Compare(a.place, b.place)
+	})
 
-	go c.readPump()
+	ratings := make([]float64, len(players))
+	places := make([]int, len(players))
+	for i, c := range players {
+		ratings[i] = c.rating
+		places[i] = c.place
+	}
+	deltas := ratingDeltas(ratings, places)
 
-	l.clients[c.id] = c
+	standings := make([]ResultEntry, len(players))
+	for i, c := range players {
+		if err := l.hub.store.SetRating(c.playerID, c.rating+deltas[i]); err != nil {
+			c.log().Error("error saving rating", "err", err)
+		}
 
-	players := []Player{}
-	for _, client := range l.clients {
-		if !client.closed.Load() {
-			players = append(players,
-				Player{ID: byte(client.id), Name: client.name})
+		standings[i] = ResultEntry{
+			PlayerID:    byte(c.id),
+			Place:       byte(c.place),
+			Score:       uint32(c.score),
+			RatingDelta: int16(math.Round(deltas[i])),
 		}
 	}
 
-	c.write <- LobbyGreeting{Players: players}
+	for _, c := range players {
+		l.checkAchievements(c, gameEvent{
+			kind:    eventGameFinished,
+			elapsed: time.Since(l.startedAt),
+			players: len(players),
+		})
+	}
+
+	for i, c := range players {
+		c.send(GameResults{
+			Place:       byte(c.place),
+			Rating:      uint16(min(max(math.Round(c.rating+deltas[i]), 0), math.MaxUint16)),
+			RatingDelta: int16(math.Round(deltas[i])),
+			Standings:   standings,
+		})
+	}
 
-	l.activeClientCount.Add(1)
-	full := l.activeClientCount.Load() == ClientsPerLobby
+	l.saveMatch(players, deltas)
 
-	return full
+	l.logger.Info("game results", "players", len(players))
 }
 
-func (l *Lobby) unregisterClient(c *Client) {
-	l.activeClientCount.Add(-1)
+// saveMatch records the finished game in the match history. players are
+// ordered by place and deltas are their rating changes.
+func (l *Lobby) saveMatch(players []*Client, deltas []float64) {
+	if len(players) == 0 {
+		return
+	}
 
-	l.broadcast(OpponentEliminated{byte(c.id)})
+	m := Match{
+		ID:      randomHex(8),
+		LobbyID: l.id,
+		Seed:    l.seed,
+		Settings: MatchSettings{
+			Private:             l.private,
+			Pack:                l.pack,
+			MaxPlayers:          l.config.ClientsPerLobby,
+			EliminationInterval: l.config.EliminationInterval.String(),
+			ChoiceCount:         l.config.ChoiceCount,
+		},
+		Started: l.startedAt,
+		Ended:   time.Now(),
+		Players: make([]MatchPlayer, len(players)),
+	}
 
-	if l.activeClientCount.Load() == 0 {
-		l.log("all clients left")
-		if !l.closed.Load() {
-			close(l.done)
-			l.closed.Store(true)
+	for i, c := range players {
+		p := MatchPlayer{
+			PlayerID:       c.playerID,
+			Name:           c.name,
+			Place:          c.place,
+			Score:          c.score,
+			CorrectAnswers: c.correctAnswers,
+			WrongAnswers:   c.wrongAnswers,
+			CoinsEarned:    c.coinsEarned,
+			CoinsSpent:     c.coinsSpent,
+			MaxDifficulty:  c.maxDifficulty,
+			MultipleChoice: c.multipleChoice,
+			Rating:         c.rating,
+			RatingDelta:    deltas[i],
+		}
+		for d, t := range c.answersByDifficulty {
+			if t.answers > 0 {
+				p.Difficulties = append(p.Difficulties, DifficultyTotals{
+					Difficulty:    uint(d),
+					Answers:       t.answers,
+					Correct:       t.correct,
+					AnswerSeconds: t.time.Seconds(),
+				})
+			}
 		}
+		for id, n := range c.powerupsBought {
+			if n > 0 {
+				if p.Powerups == nil {
+					p.Powerups = map[string]uint{}
+				}
+				p.Powerups[Powerups[id].name] = n
+			}
+		}
+		m.Players[i] = p
+	}
+
+	if err := l.hub.store.SaveMatch(m); err != nil {
+		l.logger.Error("error saving match", "err", err)
 	}
 }
 
-func (l *Lobby) broadcast(msg ServerMessage) {
+// details describes the lobby and its players.
+func (l *Lobby) details() LobbyDetails {
+	d := LobbyDetails{
+		LobbyState: l.state(),
+		Players:    make([]PlayerDetails, 0, len(l.clients)),
+	}
+
+	if l.hasStarted() {
+		d.GameTime = time.Since(l.startedAt).Round(time.Second).String()
+	}
+
 	for _, c := range l.clients {
-		if !c.closed.Load() {
-			c.write <- msg
-		}
+		d.Players = append(d.Players, PlayerDetails{
+			ID:           c.id,
+			Name:         c.name,
+			Score:        c.score,
+			Coins:        c.coins,
+			Difficulty:   c.difficulty,
+			Eliminated:   c.eliminated,
+			Disconnected: c.disconnected,
+		})
+	}
+
+	slices.SortFunc(d.Players, func(a, b PlayerDetails) int {
+		return cmp.Compare(a.ID, b.ID)
+	})
+
+	return d
+}
+
+func (l *Lobby) hasStarted() bool {
+	select {
+	case <-l.started:
+		return true
+	default:
+		return false
 	}
 }
 
-func (l *Lobby) eliminationHandler() {
-	eliminationTimer := time.NewTicker(30 * time.Second)
+// notify sends msg to the lobby, reporting false if the lobby closed.
+func (l *Lobby) notify(msg HubLobbyMessage) bool {
+	select {
+	case l.hubRead <- msg:
+		return true
+	case <-l.done:
+		return false
+	}
+}
 
-	for range eliminationTimer.C {
-		if l.activeClientCount.Load() <= 0 {
-			eliminationTimer.Stop()
-			l.log("stopping elimination handler")
-			if !l.closed.Load() {
-				close(l.done)
-				l.closed.Store(true)
-			}
-			return
+func (l *Lobby) handleMessage(msg ClientLobbyMessage) {
+	switch msg := msg.(type) {
+
+	case ClientLobbySubmission:
+		c := l.activeClient(msg.ClientID)
+		if c == nil || c.multipleChoice {
+			break
 		}
 
-		l.log("eliminating")
+		c.submitted()
 
-		const inf uint = ^uint(0)
-		var c1, c2, c3 *Client
-		min1, min2, min3 := inf, inf, inf
+		correct := c.expectedResult == int(msg.Answer)
+		c.recordAnswer(correct)
+		if correct {
+			l.correctAnswer(c)
+		}
+		l.checkAchievements(c, gameEvent{kind: eventAnswered, elapsed: time.Since(l.startedAt)})
 
-		for _, c := range l.clients {
-			if c.closed.Load() {
-				continue
-			}
+	case ClientLobbyChoiceSubmission:
+		c := l.activeClient(msg.ClientID)
+		if c == nil || !c.multipleChoice {
+			break
+		}
 
-			s := c.score
-			switch {
-			case s < min1:
-				c1, c2, c3 = c, c1, c2
-				min1, min2, min3 = s, min1, min2
-			case s < min2:
-				c2, c3 = c, c2
-				min2, min3 = s, min2
-			case s < min3:
-				c3 = c
-				min3 = s
-			}
+		c.submitted()
+		c.recordAnswer(c.expectedChoice == msg.Choice)
+
+		// a wrong pick moves on to a new question, otherwise the
+		// options could simply be tried one after another
+		if c.expectedChoice != msg.Choice {
+			c.nextQuestion(c.difficulty)
+		} else {
+			l.correctAnswer(c)
 		}
+		l.checkAchievements(c, gameEvent{kind: eventAnswered, elapsed: time.Since(l.startedAt)})
 
-		if c1 != nil {
-			c1.write <- Eliminated{byte(l.activeClientCount.Load())}
-			l.activeClientCount.Add(-1)
-			c1.closed.Store(true)
-			l.broadcast(OpponentEliminated{byte(c1.id)})
+	case ClientLobbyPowerupPurchase:
+		c := l.activeClient(msg.ClientID)
+		if c == nil {
+			break
 		}
 
-		if c2 != nil {
-			c2.write <- Eliminated{byte(l.activeClientCount.Load())}
-			l.activeClientCount.Add(-1)
-			c2.closed.Store(true)
-			l.broadcast(OpponentEliminated{byte(c2.id)})
+		l.purchasePowerup(c, msg)
+
+	case ClientLobbyRateLimited:
+		if c, ok := l.clients[msg.ClientID]; ok {
+			c.send(RateLimited{Throttled: msg.Throttled})
 		}
 
-		if c3 != nil {
-			c3.write <- Eliminated{byte(l.activeClientCount.Load())}
-			l.activeClientCount.Add(-1)
-			c3.closed.Store(true)
-			l.broadcast(OpponentEliminated{byte(c3.id)})
+	case ClientLobbyLeaderboardRequest:
+		if c, ok := l.clients[msg.ClientID]; ok {
+			l.sendGlobalLeaderboard(c, msg.Period, msg.Category)
 		}
+	}
+}
 
-		l.log("active clients after eliminating: %d", l.activeClientCount.Load())
+// activeClient returns the client with the given id if it is still playing.
+func (l *Lobby) activeClient(id ClientId) *Client {
+	c, ok := l.clients[id]
+	if !ok || !c.playing || c.eliminated || c.disconnected {
+		return nil
 	}
+	return c
 }
 
-func (l *Lobby) handleLobbyStatusEffect(cl ClientLobbyStatusEffect) {
-	c := l.clients[cl.ClientID]
+func (l *Lobby) correctAnswer(c *Client) {
+	c.correctAnswer()
+	l.scoresChanged = true
+}
 
-	switch cl.Powerup {
-	case DoubleTapPowerup:
+// sendLeaderboard sends the entries whose score or rank changed since the
+// last snapshot, and the whole leaderboard to clients that missed one.
+func (l *Lobby) sendLeaderboard() {
+	stale := false
+	for _, c := range l.clients {
+		stale = stale || c.staleLeaderboard && !c.disconnected && !c.eliminated
+	}
 
-	case CoinLeakPowerup:
-		c.coinMult = max(c.coinMult-0.1, 0.0)
-		c.write <- MultipliersChanged{
-			ScoreMult: c.scoreMult,
-			CoinMult:  c.coinMult,
+	if !l.scoresChanged && !stale {
+		return
+	}
+	l.scoresChanged = false
+
+	ranked := make([]*Client, 0, len(l.clients))
+	for _, c := range l.clients {
+		ranked = append(ranked, c)
+	}
+	slices.SortFunc(ranked, func(a, b *Client) int {
+		if a.score != b.score {
+			return cmp.Compare(b.score, a.score)
 		}
+		return cmp.Compare(a.id, b.id)
+	})
 
-	case HardModePowerup:
-		newDifficulty := min(10, c.difficulty + 5)
-		question, expectedResult := GenerateQuestion(newDifficulty)
-		c.expectedResult = expectedResult
-		c.write <- NewQuestion{
-			Question: question,
-			Difficulty: byte(newDifficulty),
+	all := make([]LeaderboardEntry, len(ranked))
+	changed := []LeaderboardEntry{}
+
+	for i, c := range ranked {
+		rank := i + 1
+		if i > 0 && c.score == ranked[i-1].score {
+			rank = int(all[i-1].Rank)
+		}
+
+		entry := LeaderboardEntry{
+			PlayerID: byte(c.id),
+			Score:    uint32(c.score),
+			Rank:     byte(min(rank, 255)),
+		}
+		all[i] = entry
+
+		if last, ok := l.standings[c.id]; !ok || last != entry {
+			changed = append(changed, entry)
+			l.standings[c.id] = entry
+		}
+	}
+
+	for _, c := range l.clients {
+		if c.disconnected || c.eliminated {
+			continue
+		}
+
+		if c.staleLeaderboard {
+			c.staleLeaderboard = false
+			c.send(LeaderboardSnapshot{Entries: all})
+		} else if len(changed) > 0 {
+			c.send(LeaderboardSnapshot{Entries: changed})
 		}
 	}
 }
 
-func (l *Lobby) close() {
-	l.log("closing")
+// globalLeaderboardSize is the number of entries sent of a global
+// leaderboard.
+const globalLeaderboardSize = 10
+
+// sendGlobalLeaderboard sends a client the top of a global leaderboard.
+func (l *Lobby) sendGlobalLeaderboard(c *Client, period, category byte) {
+	if period >= periodCount || category >= categoryCount {
+		c.log().Warn("received invalid leaderboard", "period", period, "category", category)
+		return
+	}
+
+	board := l.hub.store.Leaderboard(period, category, globalLeaderboardSize)
+
+	msg := GlobalLeaderboard{
+		Period:   period,
+		Category: category,
+		Entries:  make([]GlobalLeaderboardEntry, len(board.Entries)),
+	}
+	if !board.ResetsAt.IsZero() {
+		msg.ResetsIn = uint32(max(time.Until(board.ResetsAt).Seconds(), 0))
+	}
+	for i, r := range board.Entries {
+		msg.Entries[i] = globalLeaderboardEntry(r)
+	}
+	if r, ok := l.hub.store.LeaderboardRank(period, category, c.playerID); ok {
+		msg.Own = globalLeaderboardEntry(r)
+	}
+
+	c.send(msg)
+}
+
+func globalLeaderboardEntry(r LeaderboardRank) GlobalLeaderboardEntry {
+	return GlobalLeaderboardEntry{
+		Rank:  uint16(min(r.Rank, math.MaxUint16)),
+		Value: uint32(min(max(r.Value, 0), math.MaxUint32)),
+		Name:  r.Name,
+	}
+}
+
+func (l *Lobby) purchasePowerup(c *Client, msg ClientLobbyPowerupPurchase) {
+	if msg.PowerupID >= byte(len(Powerups)) {
+		c.log().Warn("received invalid powerup id", "powerup", msg.PowerupID)
+		return
+	}
+
+	powerup := Powerups[msg.PowerupID]
+
+	if powerup.cost > c.coins {
+		return
+	}
+
+	var target *Client
+	switch msg.PowerupID {
+	case DoubleTapPowerup, CoinLeakPowerup, HardModePowerup:
+		target = l.activeClient(msg.AffectedPlayer)
+		if target == nil {
+			c.log().Info("received powerup for inactive player",
+				"powerup", msg.PowerupID, "target", msg.AffectedPlayer)
+			return
+		}
+	}
+	if msg.PowerupID == HardModePowerup && target == c {
+		c.log().Info("received hard mode for the buyer")
+		return
+	}
+
+	c.coins -= powerup.cost
+	c.coinsSpent += powerup.cost
+	c.powerupsBought[msg.PowerupID]++
+	metrics.powerups[msg.PowerupID].Add(1)
+
+	switch msg.PowerupID {
+
+	case CoinMultPowerup:
+		c.coinMult += 0.2
+		c.sendMultipliers()
+
+	case ScoreMultPowerup:
+		c.scoreMult += 0.1
+		c.sendMultipliers()
+
+	case SkipQuestionPowerup:
+		c.nextQuestion(c.difficulty)
+
+	case EasyModePowerup:
+		c.difficulty--
+		c.answered = 0
+
+		c.nextQuestion(c.difficulty)
+
+	case DoubleTapPowerup, CoinLeakPowerup, HardModePowerup:
+		l.applyStatusEffect(target, msg.PowerupID, c)
+	}
+
+	c.send(PurchaseConfirmed{
+		NewCoins: uint32(c.coins),
+	})
+}
+
+func (l *Lobby) registerClient(c *Client) bool {
+	c.id = l.nextClientID
+	l.nextClientID++
+	l.ratingSum.Add(int64(math.Round(c.rating)))
+
+	l.broadcast(NewRegisteredPlayer{
+		Player{ID: byte(c.id), Name: c.name}})
+
+	c.unregister = l.unregister
+	c.read = l.lobbyRead
+	c.lobbyDone = l.done
+	c.questions = l.questions
+	c.rng = l.rng
+	c.logger.Store(l.logger.With("client_id", c.id))
+
+	l.clients[c.id] = c
+
+	players := []Player{}
 	for _, client := range l.clients {
-		client.conn.Close()
+		if !client.disconnected && !client.eliminated {
+			players = append(players,
+				Player{ID: byte(client.id), Name: client.name})
+		}
+	}
+
+	c.send(LobbyGreeting{Players: players})
+
+	go c.readPump()
+
+	l.activeClientCount.Add(1)
+	full := int(l.activeClientCount.Load()) == l.config.ClientsPerLobby
+
+	return full
+}
+
+func (l *Lobby) unregisterClient(c *Client) {
+	if c.disconnected {
+		return
+	}
+
+	c.disconnected = true
+	close(c.write)
+
+	if c.eliminated {
+		return
+	}
+
+	if c.playing {
+		metrics.disconnectEliminations.Add(1)
+		c.place = int(l.activeClientCount.Load())
+	}
+	if !l.hasStarted() {
+		l.ratingSum.Add(-int64(math.Round(c.rating)))
+	}
+
+	c.eliminated = true
+	c.playing = false
+	l.activeClientCount.Add(-1)
+
+	l.broadcast(OpponentEliminated{byte(c.id)})
+}
+
+func (l *Lobby) broadcast(msg ServerMessage) {
+	for _, c := range l.clients {
+		if !c.disconnected && !c.eliminated {
+			c.send(msg)
+		}
 	}
-	l.hub.unregisterLobby(l)
 }
 
-// GenerateQuestion returns (question string, expectedResult)
-func GenerateQuestion(difficulty uint) (string, int) {
-	switch difficulty {
-	case 1: // one-digit add & sub
-		a, b := randInt(1, 9), randInt(1, 9)
-		if rand.IntN(2) == 0 {
-			return fmt.Sprintf("%d + %d = ", a, b), a + b
+// broadcastAll sends msg to every connected client, eliminated or not.
+func (l *Lobby) broadcastAll(msg ServerMessage) {
+	for _, c := range l.clients {
+		if !c.disconnected {
+			c.send(msg)
 		}
-		return fmt.Sprintf("%d - %d = ", a, b), a - b
+	}
+}
 
-	case 2: // two-digit add & sub
-		a, b := randInt(10, 99), randInt(10, 99)
-		if rand.IntN(2) == 0 {
-			return fmt.Sprintf("%d + %d = ", a, b), a + b
+// eliminate knocks out the three lowest scoring clients.
+func (l *Lobby) eliminate() {
+	const inf uint = ^uint(0)
+	var c1, c2, c3 *Client
+	min1, min2, min3 := inf, inf, inf
+
+	for _, c := range l.clients {
+		if c.disconnected || c.eliminated {
+			continue
 		}
-		return fmt.Sprintf("%d - %d = ", a, b), a - b
 
-	case 3: // one-digit mult
-		a, b := randInt(1, 9), randInt(1, 9)
-		return fmt.Sprintf("%d × %d = ", a, b), a * b
+		s := c.score
+		switch {
+		case s < min1:
+			c1, c2, c3 = c, c1, c2
+			min1, min2, min3 = s, min1, min2
+		case s < min2:
+			c2, c3 = c, c2
+			min2, min3 = s, min2
+		case s < min3:
+			c3 = c
+			min3 = s
+		}
+	}
 
-	case 4: // one & two-digit mult
-		a, b := randInt(1, 9), randInt(10, 99)
-		if rand.IntN(2) == 0 {
-			a, b = b, a
+	for _, c := range []*Client{c1, c2, c3} {
+		if c == nil {
+			continue
 		}
-		return fmt.Sprintf("%d × %d = ", a, b), a * b
 
-	case 5: // one & two-digit div (integer only)
-		b := randInt(1, 9)
-		result := randInt(2, 9)
-		a := b * result
-		return fmt.Sprintf("%d ÷ %d = ", a, b), result
+		metrics.scoreEliminations.Add(1)
+		l.eliminateClient(c)
 
-	case 6: // three numbers one-digit mult add
-		a, b, c := randInt(1, 9), randInt(1, 9), randInt(1, 9)
-		if rand.IntN(2) == 0 {
-			return fmt.Sprintf("%d × %d + %d = ", a, b, c), a*b + c
+		if by := c.hardModeBy; by != nil {
+			by.hardModeEliminations++
+			l.checkAchievements(by, gameEvent{kind: eventOpponentEliminated, elapsed: time.Since(l.startedAt)})
 		}
-		return fmt.Sprintf("%d + %d × %d = ", a, b, c), a + b*c
+	}
+
+	l.logger.Debug("eliminated lowest scores", "active", l.activeClientCount.Load())
+}
+
+func (l *Lobby) eliminateClient(c *Client) {
+	c.place = int(l.activeClientCount.Load())
+	c.send(Eliminated{byte(c.place)})
+	l.activeClientCount.Add(-1)
+	c.eliminated = true
+	c.playing = false
+	l.broadcast(OpponentEliminated{byte(c.id)})
+}
+
+// checkIdle warns and eventually eliminates players that stopped answering,
+// as configured by the lobby's AFK policy.
+func (l *Lobby) checkIdle() {
+	now := time.Now()
 
-	case 7: // three-digit add & sub
-		a, b := randInt(100, 999), randInt(100, 999)
-		if rand.IntN(2) == 0 {
-			return fmt.Sprintf("%d + %d = ", a, b), a + b
+	for _, c := range l.clients {
+		if !c.playing || c.eliminated || c.disconnected {
+			continue
 		}
-		return fmt.Sprintf("%d - %d = ", a, b), a - b
 
-	case 8: // 3 one-digit mults
-		a, b, c := randInt(1, 9), randInt(1, 9), randInt(1, 9)
-		return fmt.Sprintf("%d × %d × %d = ", a, b, c), a * b * c
+		idle := now.Sub(c.lastSubmission)
 
-	case 9: // three and one-digit div (integer)
-		b := randInt(2, 9)
-		result := randInt(10, 99)
-		a := b * result
-		return fmt.Sprintf("%d ÷ %d = ", a, b), result
+		if l.config.AFK.EliminateAfter > 0 && idle >= l.config.AFK.EliminateAfter {
+			c.log().Info("eliminated for being idle", "idle", idle.Round(time.Second))
+			metrics.idleEliminations.Add(1)
+			l.eliminateClient(c)
+			continue
+		}
 
-	case 10: // two-digit mult
-		a, b := randInt(10, 99), randInt(10, 99)
-		return fmt.Sprintf("%d × %d = ", a, b), a * b
+		if l.config.AFK.WarnAfter > 0 && idle >= l.config.AFK.WarnAfter && !c.warnedIdle {
+			c.warnedIdle = true
 
-	default:
-		return "invalid difficulty", 0
+			var left time.Duration
+			if l.config.AFK.EliminateAfter > 0 {
+				left = l.config.AFK.EliminateAfter - idle
+			}
+			c.send(IdleWarning{SecondsLeft: uint16(left.Round(time.Second).Seconds())})
+		}
 	}
 }
 
-func (l *Lobby) log(format string, v ...any) {
-	log.Printf("lobby %d: %s", l.id, fmt.Sprintf(format, v...))
+// applyStatusEffect applies the powerup bought by the client by to c.
+func (l *Lobby) applyStatusEffect(c *Client, powerup byte, by *Client) {
+	switch powerup {
+	case DoubleTapPowerup:
+
+	case CoinLeakPowerup:
+		c.coinMult = max(c.coinMult-0.1, 0.0)
+		c.sendMultipliers()
+
+	case HardModePowerup:
+		c.nextQuestion(min(10, c.difficulty+5))
+		c.hardModeBy = by
+	}
 }
 
-func randInt(min, max int) int {
-	return rand.IntN(max-min+1) + min
+// close stops the lobby. Closing the clients' write channels makes their
+// write pumps close the connections once pending messages are sent.
+func (l *Lobby) close() {
+	l.logger.Debug("closing")
+
+	l.open.Store(false)
+	close(l.done)
+
+	for _, client := range l.clients {
+		if !client.disconnected {
+			client.disconnected = true
+			close(client.write)
+		}
+	}
+
+	l.hub.unregisterLobby(l)
 }
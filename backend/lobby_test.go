@@ -0,0 +1,279 @@
+package main
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// testClient plays a game over a websocket connection to a test server.
+type testClient struct {
+	t    *testing.T
+	conn *websocket.Conn
+
+	// received counts the messages received by opcode
+	received map[byte]int
+	playerID string
+}
+
+// dialTestClient connects to the server and registers with the given
+// credentials, which are left out if playerID is empty.
+func dialTestClient(t *testing.T, server *httptest.Server, name, playerID, token string) *testClient {
+	t.Helper()
+
+	conn := dialWs(t, server, name)
+
+	register := append([]byte{OpcodeRegister, byte(len(name))}, name...)
+	if playerID != "" {
+		register = append(register, byte(len(playerID)))
+		register = append(register, playerID...)
+		register = append(register, byte(len(token)))
+		register = append(register, token...)
+	}
+	if err := conn.WriteMessage(websocket.BinaryMessage, register); err != nil {
+		t.Fatalf("registering %s: %v", name, err)
+	}
+
+	return &testClient{t: t, conn: conn, received: map[byte]int{}}
+}
+
+func dialWs(t *testing.T, server *httptest.Server, name string) *websocket.Conn {
+	t.Helper()
+
+	url := "ws" + strings.TrimPrefix(server.URL, "http") + "/ws"
+	conn, _, err := websocket.DefaultDialer.Dial(url, nil)
+	if err != nil {
+		t.Fatalf("dialing %s: %v", name, err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return conn
+}
+
+// next returns the next message from the server.
+func (c *testClient) next() []byte {
+	c.t.Helper()
+
+	c.conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	_, msg, err := c.conn.ReadMessage()
+	if err != nil {
+		c.t.Fatalf("reading message: %v", err)
+	}
+	return msg
+}
+
+// play answers every question correctly, buying a coin multiplier whenever
+// it can afford one, until the connection closes or the game ends.
+func (c *testClient) play() {
+	for {
+		c.conn.SetReadDeadline(time.Now().Add(10 * time.Second))
+		_, msg, err := c.conn.ReadMessage()
+		if err != nil {
+			return
+		}
+		c.received[msg[0]]++
+
+		switch msg[0] {
+		case OpcodeAccountCreated:
+			c.playerID = string(msg[2 : 2+msg[1]])
+
+		case OpcodeNewQuestion:
+			text := string(msg[4 : 4+binary.BigEndian.Uint16(msg[2:])])
+			e, err := ParseExpr(strings.TrimSuffix(text, " = "))
+			if err != nil {
+				c.t.Errorf("parsing question %q: %v", text, err)
+				return
+			}
+			answer, err := e.Eval()
+			if err != nil {
+				c.t.Errorf("evaluating question %q: %v", text, err)
+				return
+			}
+
+			submission := binary.BigEndian.AppendUint32([]byte{OpcodeSubmission}, uint32(int32(answer)))
+			c.conn.WriteMessage(websocket.BinaryMessage, submission)
+
+		case OpcodeCorrectSubmission:
+			coins := binary.BigEndian.Uint32(msg[5:])
+			if uint(coins) >= Powerups[CoinMultPowerup].cost {
+				c.conn.WriteMessage(websocket.BinaryMessage, []byte{OpcodePowerup, CoinMultPowerup, 0})
+			}
+
+		case OpcodeGameResults:
+			return
+		}
+	}
+}
+
+func TestLobbyGame(t *testing.T) {
+	const clients = 8
+
+	config := DefaultConfig()
+	config.ClientsPerLobby = clients
+	config.StartDelay = time.Minute
+	config.EliminationInterval = 300 * time.Millisecond
+	config.LeaderboardInterval = 50 * time.Millisecond
+	config.AFK = AFKPolicy{}
+	config.RateLimits.Submission = RateLimit{Rate: 1000, Burst: 1000}
+	config.RateLimits.Powerup = RateLimit{Rate: 1000, Burst: 1000}
+
+	h := newTestHub(t, config)
+	server := httptest.NewServer(http.HandlerFunc(h.ServeWs))
+	defer server.Close()
+
+	players := make([]*testClient, clients)
+	for i := range players {
+		players[i] = dialTestClient(t, server, fmt.Sprintf("p%d", i), "", "")
+	}
+
+	var wg sync.WaitGroup
+	for _, c := range players {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			c.play()
+		}()
+	}
+
+	// the lobby fills up and starts right away, draining lets the game
+	// finish
+	time.Sleep(100 * time.Millisecond)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	h.Drain(ctx)
+	if ctx.Err() != nil {
+		t.Error("game didn't finish before the drain deadline")
+	}
+
+	wg.Wait()
+
+	for i, c := range players {
+		for _, op := range []byte{
+			OpcodeStartGame,
+			OpcodeCorrectSubmission,
+			OpcodePurchaseConfirmed,
+			OpcodeServerShuttingDown,
+			OpcodeGameResults,
+		} {
+			if c.received[op] == 0 {
+				t.Errorf("player %d received no message with opcode %d", i, op)
+			}
+		}
+	}
+
+	eliminated := 0
+	for _, c := range players {
+		eliminated += c.received[OpcodeEliminated]
+	}
+	if eliminated != clients {
+		t.Errorf("%d players eliminated, want %d", eliminated, clients)
+	}
+
+	for i, c := range players {
+		if matches := h.store.PlayerMatches(c.playerID, 10); len(matches) != 1 {
+			t.Errorf("player %d has %d recorded matches, want 1", i, len(matches))
+		}
+	}
+}
+
+// addTestClient adds a playing client without a connection to the lobby.
+func addTestClient(l *Lobby, id ClientId, score uint) *Client {
+	c := &Client{
+		id:           id,
+		config:       &l.config,
+		write:        make(chan ServerMessage, 64),
+		playing:      true,
+		questions:    l.questions,
+		rng:          l.rng,
+		achievements: map[string]bool{},
+		difficulty:   1,
+		scoreMult:    1,
+		coinMult:     1,
+		score:        score,
+	}
+	c.logger.Store(slog.Default())
+
+	l.clients[id] = c
+	l.activeClientCount.Add(1)
+	return c
+}
+
+func TestHardModeCredit(t *testing.T) {
+	l := newLobby(0, nil, DefaultConfig(), DefaultQuestionGenerator)
+
+	buyer := addTestClient(l, 0, 1000)
+	hit := addTestClient(l, 1, 0)
+	answered := addTestClient(l, 2, 0)
+	for id := ClientId(3); id < 6; id++ {
+		addTestClient(l, id, 1000)
+	}
+	buyer.coins = 3 * Powerups[HardModePowerup].cost
+
+	hardMode := func(target *Client) {
+		l.purchasePowerup(buyer, ClientLobbyPowerupPurchase{
+			ClientID:       buyer.id,
+			PowerupID:      HardModePowerup,
+			AffectedPlayer: target.id,
+		})
+	}
+
+	hardMode(buyer)
+	if buyer.coins != 3*Powerups[HardModePowerup].cost || buyer.hardModeBy != nil {
+		t.Fatal("bought hard mode for the buyer")
+	}
+
+	hardMode(hit)
+	hardMode(answered)
+	if hit.hardModeBy != buyer || answered.hardModeBy != buyer {
+		t.Fatal("hard mode not credited to the buyer")
+	}
+
+	l.correctAnswer(answered)
+	if answered.hardModeBy != nil {
+		t.Error("hard mode still credited after the question was answered")
+	}
+
+	l.eliminate()
+	if !hit.eliminated || !answered.eliminated {
+		t.Fatal("lowest scores not eliminated")
+	}
+	if buyer.hardModeEliminations != 1 {
+		t.Errorf("buyer credited with %d eliminations, want 1", buyer.hardModeEliminations)
+	}
+}
+
+func TestPlayerNameFromRegister(t *testing.T) {
+	config := DefaultConfig()
+	config.ClientsPerLobby = 3
+	h := newTestHub(t, config)
+	server := httptest.NewServer(http.HandlerFunc(h.ServeWs))
+	defer server.Close()
+
+	first := dialTestClient(t, server, "first", "", "")
+	for first.next()[0] != OpcodeLobbyGreeting {
+	}
+
+	second := dialTestClient(t, server, "second", "", "")
+	msg := second.next()
+	for msg[0] != OpcodeLobbyGreeting {
+		msg = second.next()
+	}
+	// a greeting lists the players by id and name
+	names := []string{}
+	for i, rest := 0, msg[2:]; i < int(msg[1]); i++ {
+		names = append(names, string(rest[2:2+rest[1]]))
+		rest = rest[2+rest[1]:]
+	}
+	if !slices.Contains(names, "first") {
+		t.Errorf("greeting lists %q, want the registered name of the first player", names)
+	}
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"cmp"
+	"net/http"
+	"slices"
+	"time"
+)
+
+// PublicLobby is a lobby players can pick to join with the lobby query
+// parameter of the websocket endpoint.
+type PublicLobby struct {
+	ID         int `json:"id"`
+	Players    int `json:"players"`
+	MaxPlayers int `json:"maxPlayers"`
+	// StartsIn is the number of seconds until the game starts at the latest
+	StartsIn int `json:"startsIn"`
+}
+
+// PublicLobbies returns the public lobbies still waiting for players, the
+// fullest first.
+func (h *Hub) PublicLobbies() []PublicLobby {
+	h.lobbiesMu.Lock()
+	defer h.lobbiesMu.Unlock()
+
+	lobbies := []PublicLobby{}
+	if h.draining.Load() {
+		return lobbies
+	}
+
+	for _, l := range h.lobbies {
+		if l.private || !l.open.Load() {
+			continue
+		}
+
+		lobbies = append(lobbies, PublicLobby{
+			ID:         l.id,
+			Players:    l.clientCount(),
+			MaxPlayers: l.config.ClientsPerLobby,
+			StartsIn:   int(max(time.Until(l.startsAt).Seconds(), 0)),
+		})
+	}
+
+	slices.SortFunc(lobbies, func(a, b PublicLobby) int {
+		return cmp.Or(cmp.Compare(b.Players, a.Players), cmp.Compare(a.ID, b.ID))
+	})
+
+	return lobbies
+}
+
+// ServeLobbies lists the joinable public lobbies as JSON.
+func (h *Hub) ServeLobbies(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Cache-Control", "no-store")
+	writeJSON(w, http.StatusOK, h.PublicLobbies())
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"io"
+	"log/slog"
+	"reflect"
+	"slices"
+	"strings"
+)
+
+// newLogger returns the logger configured by c, writing to w.
+func newLogger(c Config, w io.Writer) *slog.Logger {
+	opts := &slog.HandlerOptions{Level: c.LogLevel}
+
+	if c.LogFormat == "json" {
+		return slog.New(slog.NewJSONHandler(w, opts))
+	}
+	return slog.New(slog.NewTextHandler(w, opts))
+}
+
+// messageLog selects the message types whose every send and receive is
+// logged at debug level. Logging all traffic is far too noisy for anything
+// but chasing a protocol bug, so it is off by default.
+type messageLog struct {
+	all   bool
+	names map[string]bool
+}
+
+// newMessageLog logs the messages with the given type names, e.g.
+// "Submission" or "NewQuestion", or all of them given "all". Names are
+// matched regardless of case.
+func newMessageLog(names []string) *messageLog {
+	m := &messageLog{names: map[string]bool{}}
+	for _, name := range names {
+		if strings.EqualFold(name, "all") {
+			m.all = true
+		}
+		m.names[strings.ToLower(name)] = true
+	}
+	return m
+}
+
+func (m *messageLog) enabled(msg any) bool {
+	if !m.all && len(m.names) == 0 {
+		return false
+	}
+	return m.all || m.names[strings.ToLower(messageName(msg))]
+}
+
+// messageTypes are the messages of the protocol, whose type names
+// -log-messages accepts.
+var messageTypes = []any{
+	Register{}, Submission{}, PowerupPurchase{}, SkipWait{}, ChoiceSubmission{},
+	LeaderboardRequest{},
+
+	HubGreeting{}, LobbyGreeting{}, NewRegisteredPlayer{}, CorrectSubmission{},
+	NewQuestion{}, PurchaseConfirmed{}, StatusChanged{}, OtherPlayerStatusChanged{},
+	Eliminated{}, OpponentEliminated{}, OpponentScoreChanged{}, MultipliersChanged{},
+	StartGame{}, NewChoiceQuestion{}, LeaderboardSnapshot{}, IdleWarning{},
+	ServerShuttingDown{}, RateLimited{}, Announcement{}, GameResults{},
+	AccountCreated{}, GlobalLeaderboard{}, AchievementUnlocked{},
+}
+
+// isMessageName reports whether name is the type name of a message,
+// regardless of case.
+func isMessageName(name string) bool {
+	return slices.ContainsFunc(messageTypes, func(msg any) bool {
+		return strings.EqualFold(messageName(msg), name)
+	})
+}
+
+// messageName returns the name of msg's type without any pointer.
+func messageName(msg any) string {
+	t := reflect.TypeOf(msg)
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	return t.Name()
+}
@@ -0,0 +1,39 @@
+package main
+
+import "testing"
+
+func TestMessageLog(t *testing.T) {
+	m := newMessageLog([]string{"submission", "NEWQUESTION"})
+	for msg, want := range map[any]bool{
+		&Submission{}:       true,
+		NewQuestion{}:       true,
+		&SkipWait{}:         false,
+		CorrectSubmission{}: false,
+	} {
+		if m.enabled(msg) != want {
+			t.Errorf("logging %s: %v, want %v", messageName(msg), !want, want)
+		}
+	}
+
+	if !newMessageLog([]string{"All"}).enabled(StartGame{}) {
+		t.Error("all doesn't log every message")
+	}
+
+	// every client message can be named
+	for opcode := range byte(255) {
+		// the message is too short, but known opcodes still get one
+		msg, _ := ParseClientMessage([]byte{opcode})
+		if msg == nil {
+			continue
+		}
+		if !isMessageName(messageName(msg)) {
+			t.Errorf("client message %s can't be logged", messageName(msg))
+		}
+	}
+
+	config := DefaultConfig()
+	config.LogMessages = stringList{"submission", "Submision"}
+	if err := config.validate(); err == nil {
+		t.Error("unknown message type accepted")
+	}
+}
@@ -1,5 +1,30 @@
 package main
 
+import (
+	"errors"
+	"flag"
+	"log"
+	"log/slog"
+	"os"
+)
+
 func main() {
-	serve()
+	config, printConfig, err := LoadConfig(os.Args[1:])
+	if errors.Is(err, flag.ErrHelp) {
+		return
+	}
+	if err != nil {
+		log.Fatalf("invalid configuration: %v", err)
+	}
+
+	if printConfig {
+		if err := config.Print(os.Stdout); err != nil {
+			log.Fatalf("error printing configuration: %v", err)
+		}
+		return
+	}
+
+	slog.SetDefault(newLogger(config, os.Stderr))
+
+	serve(config)
 }
@@ -0,0 +1,172 @@
+package main
+
+import (
+	"cmp"
+	"errors"
+	"net/http"
+	"slices"
+	"strconv"
+	"time"
+)
+
+// Match is the record of a finished game.
+type Match struct {
+	ID      string `json:"id"`
+	LobbyID int    `json:"lobbyId"`
+	// Seed seeded the random questions of the game
+	Seed     uint64        `json:"seed"`
+	Settings MatchSettings `json:"settings"`
+	Started  time.Time     `json:"started"`
+	Ended    time.Time     `json:"ended"`
+	// Players are ordered by place
+	Players []MatchPlayer `json:"players"`
+}
+
+// MatchSettings are the settings of the lobby a match was played in.
+type MatchSettings struct {
+	Private             bool   `json:"private"`
+	Pack                string `json:"pack,omitempty"`
+	MaxPlayers          int    `json:"maxPlayers"`
+	EliminationInterval string `json:"eliminationInterval"`
+	ChoiceCount         int    `json:"choiceCount"`
+}
+
+// MatchPlayer is a player's result and totals in a match.
+type MatchPlayer struct {
+	PlayerID       string `json:"playerId"`
+	Name           string `json:"name"`
+	Place          int    `json:"place"`
+	Score          uint   `json:"score"`
+	CorrectAnswers uint   `json:"correctAnswers"`
+	WrongAnswers   uint   `json:"wrongAnswers"`
+	CoinsEarned    uint   `json:"coinsEarned"`
+	CoinsSpent     uint   `json:"coinsSpent"`
+	MaxDifficulty  uint   `json:"maxDifficulty"`
+	MultipleChoice bool   `json:"multipleChoice"`
+	// Powerups counts the powerups bought by name
+	Powerups map[string]uint `json:"powerups,omitempty"`
+	// Difficulties has the answers to the questions of every difficulty
+	// the player was asked
+	Difficulties []DifficultyTotals `json:"difficulties,omitempty"`
+	// Rating is the player's rating before the match
+	Rating      float64 `json:"rating"`
+	RatingDelta float64 `json:"ratingDelta"`
+}
+
+// DifficultyTotals counts a player's answers to the questions of one
+// difficulty.
+type DifficultyTotals struct {
+	Difficulty uint `json:"difficulty"`
+	Answers    uint `json:"answers"`
+	Correct    uint `json:"correct"`
+	// AnswerSeconds is the time taken for all answers
+	AnswerSeconds float64 `json:"answerSeconds"`
+}
+
+const (
+	defaultMatchLimit = 20
+	maxMatchLimit     = 100
+)
+
+// SaveMatch records a finished match.
+func (s *Store) SaveMatch(m Match) error {
+	if err := s.matches.put(m.ID, m); err != nil {
+		return err
+	}
+	s.leaderboards.add(m)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.indexMatch(m)
+	return nil
+}
+
+// indexMatch adds the match to the index of its players' matches. A player
+// that joined the match more than once gets it listed once. It must be
+// called with mu held.
+func (s *Store) indexMatch(m Match) {
+	for _, p := range m.Players {
+		ids := s.playerMatches[p.PlayerID]
+		// matches are indexed one at a time, so a repeated player finds
+		// the match last in their list
+		if len(ids) > 0 && ids[len(ids)-1] == m.ID {
+			continue
+		}
+		s.playerMatches[p.PlayerID] = append(ids, m.ID)
+	}
+}
+
+// Match returns the match with the given ID.
+func (s *Store) Match(id string) (Match, bool) {
+	return s.matches.get(id)
+}
+
+// PlayerMatches returns up to n of the player's matches, the most recent
+// first.
+func (s *Store) PlayerMatches(playerID string, n int) []Match {
+	s.mu.Lock()
+	ids := s.playerMatches[playerID]
+	ids = ids[max(len(ids)-n, 0):]
+	s.mu.Unlock()
+
+	matches := make([]Match, 0, len(ids))
+	for i := len(ids) - 1; i >= 0; i-- {
+		if m, ok := s.matches.get(ids[i]); ok {
+			matches = append(matches, m)
+		}
+	}
+	return matches
+}
+
+// indexMatches builds the index of every player's matches and the
+// leaderboards from the loaded matches.
+func (s *Store) indexMatches() {
+	var all []Match
+	s.matches.each(func(_ string, m Match) {
+		all = append(all, m)
+	})
+
+	slices.SortFunc(all, func(a, b Match) int {
+		return cmp.Or(a.Ended.Compare(b.Ended), cmp.Compare(a.ID, b.ID))
+	})
+
+	for _, m := range all {
+		s.leaderboards.add(m)
+		s.indexMatch(m)
+	}
+}
+
+// ServePlayerMatches lists a player's most recent matches as JSON. The limit
+// query parameter sets how many.
+func (s *Store) ServePlayerMatches(w http.ResponseWriter, r *http.Request) {
+	limit := defaultMatchLimit
+	if v := r.URL.Query().Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 {
+			writeError(w, http.StatusBadRequest, errors.New("invalid limit"))
+			return
+		}
+		limit = min(n, maxMatchLimit)
+	}
+
+	id := r.PathValue("id")
+	if _, ok := s.Account(id); !ok {
+		writeError(w, http.StatusNotFound, errAccountNotFound)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, s.PlayerMatches(id, limit))
+}
+
+// ServeMatch returns a single match as JSON.
+func (s *Store) ServeMatch(w http.ResponseWriter, r *http.Request) {
+	m, ok := s.Match(r.PathValue("id"))
+	if !ok {
+		writeError(w, http.StatusNotFound, errMatchNotFound)
+		return
+	}
+	writeJSON(w, http.StatusOK, m)
+}
+
+var errMatchNotFound = errors.New("match not found")
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestPlayerInMatchTwice(t *testing.T) {
+	dir := t.TempDir()
+	s, err := OpenStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// one account joined the lobby from two tabs
+	err = s.SaveMatch(Match{ID: "m", Ended: time.Now(), Players: []MatchPlayer{
+		{PlayerID: "a", Place: 1},
+		{PlayerID: "b", Place: 2},
+		{PlayerID: "a", Place: 3},
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	check := func() {
+		t.Helper()
+		if n := len(s.PlayerMatches("a", 10)); n != 1 {
+			t.Errorf("match listed %d times, want once", n)
+		}
+		if n := s.PlayerStats("a").GamesPlayed; n != 1 {
+			t.Errorf("%d games played, want 1", n)
+		}
+	}
+	check()
+
+	// the index built when opening the store must agree
+	s.Close()
+	if s, err = OpenStore(dir); err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	check()
+}
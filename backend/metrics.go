@@ -0,0 +1,201 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"strconv"
+	"sync/atomic"
+	"time"
+)
+
+// metrics are the server's counters, exposed in the Prometheus text format
+// by ServeMetrics. Gauges such as the number of lobbies are read from the
+// hub when scraped instead.
+var metrics struct {
+	// messages by opcode, received counts every message read from a client
+	// including those dropped by rate limiting
+	received    [256]atomic.Uint64
+	sent        [256]atomic.Uint64
+	rateLimited [256]atomic.Uint64
+
+	// answer latency is measured from sending a question to the answer
+	correctAnswers histogram
+	wrongAnswers   histogram
+
+	powerups [HardModePowerup + 1]atomic.Uint64
+
+	scoreEliminations      atomic.Uint64
+	idleEliminations       atomic.Uint64
+	disconnectEliminations atomic.Uint64
+
+	gamesStarted atomic.Uint64
+}
+
+// answerLatencyBuckets are the upper bounds in seconds of the answer latency
+// histogram buckets.
+var answerLatencyBuckets = []float64{0.5, 1, 2, 3, 5, 8, 13, 20, 30, 60}
+
+func init() {
+	metrics.correctAnswers.init(answerLatencyBuckets)
+	metrics.wrongAnswers.init(answerLatencyBuckets)
+}
+
+// histogram counts observations into cumulative buckets.
+type histogram struct {
+	bounds []float64
+	counts []atomic.Uint64
+
+	count atomic.Uint64
+	// sum holds the bits of a float64
+	sum atomic.Uint64
+}
+
+func (h *histogram) init(bounds []float64) {
+	h.bounds = bounds
+	h.counts = make([]atomic.Uint64, len(bounds))
+}
+
+func (h *histogram) observe(v float64) {
+	for i, b := range h.bounds {
+		if v <= b {
+			h.counts[i].Add(1)
+		}
+	}
+	h.count.Add(1)
+
+	for {
+		old := h.sum.Load()
+		if h.sum.CompareAndSwap(old, math.Float64bits(math.Float64frombits(old)+v)) {
+			return
+		}
+	}
+}
+
+// observeAnswer records how long a client took to answer a question.
+func observeAnswer(latency time.Duration, correct bool) {
+	if correct {
+		metrics.correctAnswers.observe(latency.Seconds())
+	} else {
+		metrics.wrongAnswers.observe(latency.Seconds())
+	}
+}
+
+// ServeMetrics writes the metrics in the Prometheus text exposition format.
+func (h *Hub) ServeMetrics(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+
+	bw := bufio.NewWriter(w)
+	h.writeMetrics(bw)
+	bw.Flush()
+}
+
+func (h *Hub) writeMetrics(w io.Writer) {
+	m := metricsWriter{w: w}
+
+	m.header("arithmetic_connections", "gauge", "Open websocket connections.")
+	m.sample("arithmetic_connections", nil, float64(h.conns.count()))
+
+	var waiting, playing int
+	h.lobbiesMu.Lock()
+	players := make(map[int]int, len(h.lobbies))
+	for _, l := range h.lobbies {
+		if l.hasStarted() {
+			playing++
+		} else {
+			waiting++
+		}
+		players[l.id] = l.clientCount()
+	}
+	h.lobbiesMu.Unlock()
+
+	m.header("arithmetic_lobbies", "gauge", "Lobbies by state.")
+	m.sample("arithmetic_lobbies", []string{"state", "waiting"}, float64(waiting))
+	m.sample("arithmetic_lobbies", []string{"state", "playing"}, float64(playing))
+
+	m.header("arithmetic_lobby_players", "gauge", "Players still in the game of each lobby.")
+	for id, n := range players {
+		m.sample("arithmetic_lobby_players", []string{"lobby_id", strconv.Itoa(id)}, float64(n))
+	}
+
+	m.header("arithmetic_games_started_total", "counter", "Games started.")
+	m.sample("arithmetic_games_started_total", nil, float64(metrics.gamesStarted.Load()))
+
+	m.opcodes("arithmetic_messages_received_total", "Messages received from clients by opcode.", &metrics.received)
+	m.opcodes("arithmetic_messages_sent_total", "Messages sent to clients by opcode.", &metrics.sent)
+	m.opcodes("arithmetic_messages_rate_limited_total", "Client messages dropped by rate limiting by opcode.", &metrics.rateLimited)
+
+	m.header("arithmetic_answer_latency_seconds", "histogram", "Time from sending a question to receiving an answer.")
+	m.histogram("arithmetic_answer_latency_seconds", []string{"result", "correct"}, &metrics.correctAnswers)
+	m.histogram("arithmetic_answer_latency_seconds", []string{"result", "wrong"}, &metrics.wrongAnswers)
+
+	m.header("arithmetic_powerup_purchases_total", "counter", "Powerups bought by type.")
+	for id := range metrics.powerups {
+		m.sample("arithmetic_powerup_purchases_total", []string{"powerup", Powerups[id].name},
+			float64(metrics.powerups[id].Load()))
+	}
+
+	m.header("arithmetic_eliminations_total", "counter", "Players eliminated by reason.")
+	m.sample("arithmetic_eliminations_total", []string{"reason", "score"}, float64(metrics.scoreEliminations.Load()))
+	m.sample("arithmetic_eliminations_total", []string{"reason", "idle"}, float64(metrics.idleEliminations.Load()))
+	m.sample("arithmetic_eliminations_total", []string{"reason", "disconnect"}, float64(metrics.disconnectEliminations.Load()))
+
+	m.header("arithmetic_send_queue_dropped_total", "counter", "Stale messages dropped from full send queues.")
+	m.sample("arithmetic_send_queue_dropped_total", nil, float64(sendQueueStats.dropped.Load()))
+	m.header("arithmetic_send_queue_disconnects_total", "counter", "Clients disconnected for a full send queue.")
+	m.sample("arithmetic_send_queue_disconnects_total", nil, float64(sendQueueStats.disconnected.Load()))
+
+	longest, total := sendQueueDepth()
+	m.header("arithmetic_send_queue_max_depth", "gauge", "Messages in the longest send queue of the connected clients.")
+	m.sample("arithmetic_send_queue_max_depth", nil, float64(longest))
+	m.header("arithmetic_send_queue_messages", "gauge", "Messages waiting in the send queues of all connected clients.")
+	m.sample("arithmetic_send_queue_messages", nil, float64(total))
+}
+
+// metricsWriter writes the Prometheus text format.
+type metricsWriter struct {
+	w io.Writer
+}
+
+func (m metricsWriter) header(name, kind, help string) {
+	fmt.Fprintf(m.w, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, kind)
+}
+
+// sample writes a value with labels given as name, value pairs.
+func (m metricsWriter) sample(name string, labels []string, v float64) {
+	fmt.Fprint(m.w, name)
+	if len(labels) > 0 {
+		fmt.Fprint(m.w, "{")
+		for i := 0; i < len(labels); i += 2 {
+			if i > 0 {
+				fmt.Fprint(m.w, ",")
+			}
+			fmt.Fprintf(m.w, "%s=%q", labels[i], labels[i+1])
+		}
+		fmt.Fprint(m.w, "}")
+	}
+	fmt.Fprintf(m.w, " %s\n", strconv.FormatFloat(v, 'g', -1, 64))
+}
+
+// opcodes writes a counter for every opcode that was counted.
+func (m metricsWriter) opcodes(name, help string, counts *[256]atomic.Uint64) {
+	m.header(name, "counter", help)
+	for op := range counts {
+		if n := counts[op].Load(); n > 0 {
+			m.sample(name, []string{"opcode", strconv.Itoa(op)}, float64(n))
+		}
+	}
+}
+
+func (m metricsWriter) histogram(name string, labels []string, h *histogram) {
+	for i, b := range h.bounds {
+		le := append(labels[:len(labels):len(labels)], "le", strconv.FormatFloat(b, 'g', -1, 64))
+		m.sample(name+"_bucket", le, float64(h.counts[i].Load()))
+	}
+	count := float64(h.count.Load())
+	m.sample(name+"_bucket", append(labels[:len(labels):len(labels)], "le", "+Inf"), count)
+	m.sample(name+"_sum", labels, math.Float64frombits(h.sum.Load()))
+	m.sample(name+"_count", labels, count)
+}
@@ -0,0 +1,36 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestSendQueueDepth(t *testing.T) {
+	h := newTestHub(t, DefaultConfig())
+
+	short := make(chan ServerMessage, 64)
+	long := make(chan ServerMessage, 64)
+	short <- HubGreeting{}
+	for range 50 {
+		long <- HubGreeting{}
+	}
+	sendQueueStats.queues.Store(short, struct{}{})
+	sendQueueStats.queues.Store(long, struct{}{})
+
+	var b strings.Builder
+	h.writeMetrics(&b)
+	if !strings.Contains(b.String(), "arithmetic_send_queue_max_depth 50\n") {
+		t.Error("metrics don't report the longest queue")
+	}
+	// other tests' clients may still be going away and add to the total
+	if _, total := sendQueueDepth(); total < 51 {
+		t.Errorf("%d messages queued, want at least 51", total)
+	}
+
+	// the depth is the current one, it goes down once queues are gone
+	sendQueueStats.queues.Delete(long)
+	sendQueueStats.queues.Delete(short)
+	if longest, _ := sendQueueDepth(); longest >= 50 {
+		t.Errorf("longest queue has %d messages after it was gone", longest)
+	}
+}
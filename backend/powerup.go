@@ -11,17 +11,18 @@ const (
 )
 
 type Powerup struct {
+	name string
 	cost uint
 }
 
 var Powerups = []Powerup{
-	CoinMultPowerup:     {cost: 20},
-	ScoreMultPowerup:    {cost: 30},
-	SkipQuestionPowerup: {cost: 50},
-	EasyModePowerup:     {cost: 200},
-	DoubleTapPowerup:    {cost: 200},
-	CoinLeakPowerup:     {cost: 300},
-	HardModePowerup:     {cost: 500},
+	CoinMultPowerup:     {name: "coin_multiplier", cost: 20},
+	ScoreMultPowerup:    {name: "score_multiplier", cost: 30},
+	SkipQuestionPowerup: {name: "skip_question", cost: 50},
+	EasyModePowerup:     {name: "easy_mode", cost: 200},
+	DoubleTapPowerup:    {name: "double_tap", cost: 200},
+	CoinLeakPowerup:     {name: "coin_leak", cost: 300},
+	HardModePowerup:     {name: "hard_mode", cost: 500},
 }
 
 const (
@@ -0,0 +1,261 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"math/rand/v2"
+	"slices"
+	"strings"
+)
+
+// maxQuestionRerolls bounds how often a repeated question is regenerated, so
+// generators with few distinct questions still make progress.
+const maxQuestionRerolls = 10
+
+// Question is a generated question together with its expected answer.
+type Question struct {
+	Text   string
+	Answer int
+
+	// distractors are plausible wrong answers, e.g. the result of applying
+	// the wrong operator to the same operands.
+	distractors []int
+}
+
+// QuestionGenerator produces the questions asked in a lobby.
+type QuestionGenerator interface {
+	Generate(r *rand.Rand, difficulty uint) Question
+}
+
+// QuestionGeneratorFunc adapts a function to a QuestionGenerator.
+type QuestionGeneratorFunc func(r *rand.Rand, difficulty uint) Question
+
+func (f QuestionGeneratorFunc) Generate(r *rand.Rand, difficulty uint) Question {
+	return f(r, difficulty)
+}
+
+// DefaultQuestionGenerator asks the built-in questions of GenerateQuestion.
+var DefaultQuestionGenerator QuestionGenerator = QuestionGeneratorFunc(GenerateQuestion)
+
+// GenerateQuestion returns a random question for the given difficulty drawn
+// from r.
+func GenerateQuestion(r *rand.Rand, difficulty uint) Question {
+	e, mistakes := generateExpr(r, difficulty)
+	if e == nil {
+		return Question{Text: "invalid difficulty"}
+	}
+
+	q, err := newQuestion(e, mistakes...)
+	if err != nil {
+		return Question{Text: "invalid question"}
+	}
+
+	return q
+}
+
+// generateExpr returns a random expression for the given difficulty along
+// with common mistakes made when solving it.
+func generateExpr(r *rand.Rand, difficulty uint) (Expr, []Expr) {
+	switch difficulty {
+	case 1: // one-digit add & sub
+		a, b := randInt(r, 1, 9), randInt(r, 1, 9)
+		return bin(randOp(r, OpAdd, OpSub), num(a), num(b)), []Expr{bin(OpSub, num(b), num(a))}
+
+	case 2: // two-digit add & sub
+		a, b := randInt(r, 10, 99), randInt(r, 10, 99)
+		return bin(randOp(r, OpAdd, OpSub), num(a), num(b)), []Expr{bin(OpSub, num(b), num(a))}
+
+	case 3: // one-digit mult
+		a, b := randInt(r, 1, 9), randInt(r, 1, 9)
+		return bin(OpMul, num(a), num(b)), []Expr{bin(OpMul, num(a), num(b+1))}
+
+	case 4: // one & two-digit mult
+		a, b := randInt(r, 1, 9), randInt(r, 10, 99)
+		if r.IntN(2) == 0 {
+			a, b = b, a
+		}
+		e := bin(OpMul, num(a), num(b))
+		return e, []Expr{bin(OpAdd, e, num(10))}
+
+	case 5: // one & two-digit div (integer only)
+		b := randInt(r, 1, 9)
+		result := randInt(r, 2, 9)
+		return bin(OpDiv, num(b*result), num(b)), nil
+
+	case 6: // three numbers one-digit mult add
+		a, b, c := randInt(r, 1, 9), randInt(r, 1, 9), randInt(r, 1, 9)
+		// ignoring precedence is the classic mistake
+		if r.IntN(2) == 0 {
+			return bin(OpAdd, bin(OpMul, num(a), num(b)), num(c)),
+				[]Expr{bin(OpMul, num(a), bin(OpAdd, num(b), num(c)))}
+		}
+		return bin(OpAdd, num(a), bin(OpMul, num(b), num(c))),
+			[]Expr{bin(OpMul, bin(OpAdd, num(a), num(b)), num(c))}
+
+	case 7: // three-digit add & sub
+		a, b := randInt(r, 100, 999), randInt(r, 100, 999)
+		return bin(randOp(r, OpAdd, OpSub), num(a), num(b)), []Expr{bin(OpSub, num(b), num(a))}
+
+	case 8: // 3 one-digit mults
+		a, b, c := randInt(r, 1, 9), randInt(r, 1, 9), randInt(r, 1, 9)
+		return bin(OpMul, bin(OpMul, num(a), num(b)), num(c)), nil
+
+	case 9: // three and one-digit div (integer)
+		b := randInt(r, 2, 9)
+		result := randInt(r, 10, 99)
+		return bin(OpDiv, num(b*result), num(b)), nil
+
+	case 10: // two-digit mult
+		a, b := randInt(r, 10, 99), randInt(r, 10, 99)
+		e := bin(OpMul, num(a), num(b))
+		return e, []Expr{bin(OpAdd, e, num(100))}
+
+	default:
+		return nil, nil
+	}
+}
+
+// newQuestion renders e as a question. The mistakes, followed by e with each
+// of its operators swapped, are used as distractors.
+func newQuestion(e Expr, mistakes ...Expr) (Question, error) {
+	answer, err := e.Eval()
+	if err != nil {
+		return Question{}, err
+	}
+
+	// players solve the rendered text, so it has to parse back into an
+	// expression with the same answer
+	text := e.String()
+	parsed, err := ParseExpr(text)
+	if err != nil {
+		return Question{}, fmt.Errorf("rendered %q doesn't parse: %w", text, err)
+	}
+	if v, err := parsed.Eval(); err != nil || v != answer {
+		return Question{}, fmt.Errorf("rendered %q doesn't evaluate to %d", text, answer)
+	}
+
+	q := Question{Text: text + " = ", Answer: answer}
+
+	// answers wildly off from the right one wouldn't fool anybody
+	plausible := max(20, 2*abs(answer))
+
+	for _, m := range append(mistakes, swappedOperators(e)...) {
+		if v, err := m.Eval(); err == nil && abs(v-answer) <= plausible {
+			q.distractors = append(q.distractors, v)
+		}
+	}
+
+	return q, nil
+}
+
+// swappedOperators returns every variant of e with exactly one of its
+// operators replaced by another.
+func swappedOperators(e Expr) []Expr {
+	b, ok := e.(BinaryExpr)
+	if !ok {
+		return nil
+	}
+
+	var variants []Expr
+	for _, op := range []Operator{OpAdd, OpSub, OpMul} {
+		if op != b.Op {
+			variants = append(variants, bin(op, b.Left, b.Right))
+		}
+	}
+	for _, l := range swappedOperators(b.Left) {
+		variants = append(variants, bin(b.Op, l, b.Right))
+	}
+	for _, r := range swappedOperators(b.Right) {
+		variants = append(variants, bin(b.Op, b.Left, r))
+	}
+
+	return variants
+}
+
+func randOp(r *rand.Rand, ops ...Operator) Operator {
+	return ops[r.IntN(len(ops))]
+}
+
+// Choices returns n shuffled options containing the answer and plausible
+// wrong answers, along with the index of the correct option.
+func (q Question) Choices(r *rand.Rand, n int) ([]int32, byte) {
+	options := []int{q.Answer}
+
+	add := func(v int) {
+		if v < math.MinInt32 || v > math.MaxInt32 {
+			return
+		}
+		if len(options) < n && !slices.Contains(options, v) {
+			options = append(options, v)
+		}
+	}
+
+	for _, d := range q.distractors {
+		add(d)
+	}
+
+	// off-by-one and near misses fill up whatever the distractors didn't
+	for offset := 1; len(options) < n; offset++ {
+		add(q.Answer + offset)
+		add(q.Answer - offset)
+	}
+
+	r.Shuffle(len(options), func(i, j int) {
+		options[i], options[j] = options[j], options[i]
+	})
+
+	choices := make([]int32, len(options))
+	var correct byte
+	for i, v := range options {
+		choices[i] = int32(v)
+		if v == q.Answer {
+			correct = byte(i)
+		}
+	}
+
+	return choices, correct
+}
+
+// Key returns a canonical form of the question in which operands of + and ×
+// are ordered, so that "3 × 4" and "4 × 3" are considered the same question.
+func (q Question) Key() string {
+	e, err := ParseExpr(q.Text)
+	if err != nil {
+		return strings.TrimSpace(q.Text)
+	}
+	return canonicalExpr(e)
+}
+
+// questionHistory remembers the keys of a client's most recent questions.
+type questionHistory struct {
+	keys []string
+	next int
+}
+
+func (h *questionHistory) contains(key string) bool {
+	return slices.Contains(h.keys, key)
+}
+
+// add records key, forgetting the oldest key once window keys are stored.
+func (h *questionHistory) add(key string, window int) {
+	if window <= 0 {
+		return
+	}
+	if len(h.keys) < window {
+		h.keys = append(h.keys, key)
+		return
+	}
+	h.keys[h.next%len(h.keys)] = key
+	h.next = (h.next + 1) % len(h.keys)
+}
+
+func abs(n int) int {
+	if n < 0 {
+		return -n
+	}
+	return n
+}
+
+func randInt(r *rand.Rand, min, max int) int {
+	return r.IntN(max-min+1) + min
+}
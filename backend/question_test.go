@@ -0,0 +1,182 @@
+package main
+
+import (
+	"math/rand/v2"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// checkQuestion checks that q's text evaluates to its answer and that its
+// choices contain the answer once.
+func checkQuestion(t *testing.T, r *rand.Rand, q Question) {
+	t.Helper()
+
+	e, err := ParseExpr(strings.TrimSuffix(q.Text, " = "))
+	if err != nil {
+		t.Fatalf("parsing %q: %v", q.Text, err)
+	}
+	answer, err := e.Eval()
+	if err != nil {
+		t.Fatalf("evaluating %q: %v", q.Text, err)
+	}
+	if answer != q.Answer {
+		t.Fatalf("%q evaluates to %d, want %d", q.Text, answer, q.Answer)
+	}
+
+	choices, correct := q.Choices(r, 4)
+	if len(choices) != 4 {
+		t.Fatalf("%q has %d choices, want 4", q.Text, len(choices))
+	}
+	for i, c := range choices {
+		if (c == int32(q.Answer)) != (i == int(correct)) {
+			t.Fatalf("%q has choices %v with the answer at %d", q.Text, choices, correct)
+		}
+	}
+}
+
+func TestGenerateQuestion(t *testing.T) {
+	r := rand.New(rand.NewPCG(1, 2))
+
+	for difficulty := uint(1); difficulty <= 10; difficulty++ {
+		for range 500 {
+			checkQuestion(t, r, GenerateQuestion(r, difficulty))
+		}
+	}
+}
+
+func TestQuestionPacks(t *testing.T) {
+	packs, err := LoadQuestionPacks("questionpacks")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(packs) == 0 {
+		t.Fatal("no question packs loaded")
+	}
+
+	// the packs shipped don't use every operator
+	packs["operators"] = &QuestionPack{Templates: []QuestionTemplate{
+		{Op: "+", A: [2]int{-50, 50}, B: [2]int{-50, 50}},
+		{Op: "-", A: [2]int{-50, 50}, B: [2]int{-50, 50}},
+		{Op: "×", A: [2]int{-20, 20}, B: [2]int{-20, 20}},
+		{Op: "÷", A: [2]int{-20, 20}, B: [2]int{1, 12}},
+		{Op: "^", A: [2]int{-5, 5}, B: [2]int{0, 5}},
+	}}
+	if err := packs["operators"].validate(); err != nil {
+		t.Fatal(err)
+	}
+
+	r := rand.New(rand.NewPCG(1, 2))
+
+	for name, p := range packs {
+		t.Run(name, func(t *testing.T) {
+			for _, tmpl := range p.Templates {
+				for range 200 {
+					checkQuestion(t, r, tmpl.generate(r))
+				}
+			}
+			for difficulty := uint(1); difficulty <= 10; difficulty++ {
+				checkQuestion(t, r, p.Generate(r, difficulty))
+			}
+		})
+	}
+}
+
+func TestQuestionTemplateRange(t *testing.T) {
+	tests := []struct {
+		tmpl QuestionTemplate
+		ok   bool
+	}{
+		{QuestionTemplate{Op: "×", A: [2]int{1, 1000}, B: [2]int{1, 1000}}, true},
+		{QuestionTemplate{Op: "×", A: [2]int{-100000, 1}, B: [2]int{1, 100000}}, false},
+		{QuestionTemplate{Op: "÷", A: [2]int{1, 100000}, B: [2]int{1, 100000}}, false},
+		{QuestionTemplate{Op: "^", A: [2]int{2, 2}, B: [2]int{0, 30}}, true},
+		{QuestionTemplate{Op: "^", A: [2]int{1, 2}, B: [2]int{60, 64}}, false},
+		{QuestionTemplate{Op: "^", A: [2]int{-3, 1}, B: [2]int{0, 20}}, false},
+	}
+
+	for _, tt := range tests {
+		if err := tt.tmpl.validate(); (err == nil) != tt.ok {
+			t.Errorf("validating %+v: got error %v, want ok %v", tt.tmpl, err, tt.ok)
+		}
+	}
+}
+
+func TestLoadQuestionPacksSkipsInvalid(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{
+		"a.json":         `{"questions": [{"question": "1 + 1", "answer": 2}]}`,
+		"b.json":         `{"questions": [{"question": "1 + 1", "answer": 3}]}`,
+		"c.json":         `{"templates": [`,
+		"duplicate.json": `{"name": "a", "questions": [{"question": "2 + 2", "answer": 4}]}`,
+		"overflow.json":  `{"templates": [{"op": "^", "a": [2, 2], "b": [40, 40]}]}`,
+	}
+	for name, data := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(data), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	packs, err := LoadQuestionPacks(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(packs) != 1 || packs["a"] == nil || packs["a"].Questions[0].Question != "1 + 1" {
+		t.Errorf("loaded packs %v, want only a", packs)
+	}
+}
+
+func TestQuestionKey(t *testing.T) {
+	tests := []struct {
+		a, b string
+		same bool
+	}{
+		{"3 × 4 = ", "4 × 3 = ", true},
+		{"3 + 4 = ", "4 + 3 = ", true},
+		{"1 + 2 + 3 = ", "3 + (2 + 1) = ", true},
+		{"2 × 3 × 4 = ", "4 × (3 × 2) = ", true},
+		{"3 + 4 × 5 = ", "5 × 4 + 3 = ", true},
+		{"(3 + 4) × 5 = ", "5 × (4 + 3) = ", true},
+		{"3 + 4 × 5 = ", "(3 + 4) × 5 = ", false},
+		{"10 - 3 = ", "3 - 10 = ", false},
+		{"12 ÷ 4 = ", "4 ÷ 12 = ", false},
+		{"2 ^ 3 = ", "3 ^ 2 = ", false},
+		{"1 + 2 × 3 = ", "2 + 1 × 3 = ", false},
+		{"(2 + 3) - 1 = ", "(3 + 2) - 1 = ", true},
+	}
+
+	for _, tt := range tests {
+		a, b := Question{Text: tt.a}.Key(), Question{Text: tt.b}.Key()
+		if (a == b) != tt.same {
+			t.Errorf("keys of %q and %q are %q and %q, want same %v", tt.a, tt.b, a, b, tt.same)
+		}
+	}
+}
+
+func TestQuestionHistory(t *testing.T) {
+	var h questionHistory
+	for _, key := range []string{"a", "b", "c"} {
+		h.add(key, 3)
+	}
+	for _, key := range []string{"a", "b", "c"} {
+		if !h.contains(key) {
+			t.Errorf("history of 3 forgot %q", key)
+		}
+	}
+
+	// adding more keys forgets the oldest ones first
+	h.add("d", 3)
+	h.add("e", 3)
+	for key, want := range map[string]bool{"a": false, "b": false, "c": true, "d": true, "e": true} {
+		if h.contains(key) != want {
+			t.Errorf("history contains %q: %v, want %v", key, !want, want)
+		}
+	}
+
+	var off questionHistory
+	off.add("a", 0)
+	if off.contains("a") {
+		t.Error("history with an empty window remembered a key")
+	}
+}
@@ -0,0 +1,215 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math/rand/v2"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// QuestionPack is a curriculum of questions loaded from a JSON file. A pack
+// can mix templated generators with fixed question/answer pairs.
+type QuestionPack struct {
+	Name        string             `json:"name"`
+	Description string             `json:"description"`
+	Templates   []QuestionTemplate `json:"templates"`
+	Questions   []FixedQuestion    `json:"questions"`
+}
+
+// QuestionTemplate generates "a op b" questions with operands drawn from the
+// inclusive ranges A and B. Op is one of + - × ÷ ^. For division A is the
+// range of the quotient so that every question has an integer answer.
+type QuestionTemplate struct {
+	Op            string `json:"op"`
+	A             [2]int `json:"a"`
+	B             [2]int `json:"b"`
+	MinDifficulty uint   `json:"minDifficulty"`
+}
+
+// FixedQuestion is a single question. Its answer is evaluated from the
+// question when the pack is loaded; an Answer given in the file must match.
+type FixedQuestion struct {
+	Question      string `json:"question"`
+	Answer        *int   `json:"answer"`
+	MinDifficulty uint   `json:"minDifficulty"`
+
+	expr Expr
+}
+
+// Generate picks a random question from the templates and fixed questions
+// available at the given difficulty.
+func (p *QuestionPack) Generate(r *rand.Rand, difficulty uint) Question {
+	var templates []QuestionTemplate
+	for _, t := range p.Templates {
+		if t.MinDifficulty <= difficulty {
+			templates = append(templates, t)
+		}
+	}
+
+	var questions []FixedQuestion
+	for _, q := range p.Questions {
+		if q.MinDifficulty <= difficulty {
+			questions = append(questions, q)
+		}
+	}
+
+	// every item being gated behind a higher difficulty falls back to the
+	// whole pack rather than producing nothing
+	if len(templates)+len(questions) == 0 {
+		templates, questions = p.Templates, p.Questions
+	}
+
+	i := r.IntN(len(templates) + len(questions))
+	if i < len(templates) {
+		return templates[i].generate(r)
+	}
+
+	q, err := newQuestion(questions[i-len(templates)].expr)
+	if err != nil {
+		return Question{Text: "invalid question"}
+	}
+	return q
+}
+
+func (t QuestionTemplate) generate(r *rand.Rand) Question {
+	q, err := newQuestion(t.expr(randInt(r, t.A[0], t.A[1]), randInt(r, t.B[0], t.B[1])))
+	if err != nil {
+		return Question{Text: "invalid question"}
+	}
+	return q
+}
+
+// expr returns the question for the operands a and b.
+func (t QuestionTemplate) expr(a, b int) Expr {
+	op := Operator([]rune(t.Op)[0])
+	if op == OpDiv {
+		a *= b
+	}
+	return bin(op, num(a), num(b))
+}
+
+func (t QuestionTemplate) validate() error {
+	switch t.Op {
+	case "+", "-", "×", "÷", "^":
+	default:
+		return fmt.Errorf("unknown operator %q", t.Op)
+	}
+	if t.A[0] > t.A[1] || t.B[0] > t.B[1] {
+		return errors.New("operand range minimum is greater than its maximum")
+	}
+	if t.Op == "÷" && t.B[0] <= 0 && t.B[1] >= 0 {
+		return errors.New("divisor range includes zero")
+	}
+	if t.Op == "^" && (t.B[0] < 0 || t.B[1] > 64) {
+		return errors.New("exponent range must be within 0 to 64")
+	}
+
+	// the answers furthest from zero are found at the ends of the ranges,
+	// and the largest odd and even exponents for powers of negative numbers
+	bs := []int{t.B[0], t.B[1]}
+	if t.Op == "^" && t.B[1] > t.B[0] {
+		bs = append(bs, t.B[1]-1)
+	}
+	for _, a := range t.A {
+		for _, b := range bs {
+			if _, err := t.expr(a, b).Eval(); err != nil {
+				return fmt.Errorf("%d %s %d: %w", a, t.Op, b, err)
+			}
+		}
+	}
+	return nil
+}
+
+// parse parses and evaluates the question, checking it against the answer
+// given in the pack.
+func (q *FixedQuestion) parse() error {
+	e, err := ParseExpr(q.Question)
+	if err != nil {
+		return err
+	}
+
+	answer, err := e.Eval()
+	if err != nil {
+		return err
+	}
+
+	if q.Answer != nil && *q.Answer != answer {
+		return fmt.Errorf("%q evaluates to %d, not %d", q.Question, answer, *q.Answer)
+	}
+
+	q.expr = e
+	q.Answer = &answer
+	return nil
+}
+
+func (p *QuestionPack) validate() error {
+	if len(p.Templates) == 0 && len(p.Questions) == 0 {
+		return errors.New("pack has no templates or questions")
+	}
+	for i, t := range p.Templates {
+		if err := t.validate(); err != nil {
+			return fmt.Errorf("template %d: %w", i, err)
+		}
+	}
+	for i := range p.Questions {
+		if err := p.Questions[i].parse(); err != nil {
+			return fmt.Errorf("question %d: %w", i, err)
+		}
+	}
+	return nil
+}
+
+// LoadQuestionPacks reads every .json file in dir as a QuestionPack, keyed by
+// its name. Packs without a name are named after their file. Files that
+// aren't valid packs, or reuse the name of an earlier pack, are logged and
+// skipped.
+func LoadQuestionPacks(dir string) (map[string]*QuestionPack, error) {
+	paths, err := filepath.Glob(filepath.Join(dir, "*.json"))
+	if err != nil {
+		return nil, err
+	}
+
+	packs := map[string]*QuestionPack{}
+	for _, path := range paths {
+		p, err := loadQuestionPack(path)
+		if err != nil {
+			slog.Error("skipping question pack", "path", path, "err", err)
+			continue
+		}
+
+		if _, ok := packs[p.Name]; ok {
+			slog.Error("skipping question pack", "path", path, "err", fmt.Errorf("duplicate question pack %q", p.Name))
+			continue
+		}
+
+		packs[p.Name] = p
+	}
+
+	return packs, nil
+}
+
+// loadQuestionPack reads and validates the pack in a file.
+func loadQuestionPack(path string) (*QuestionPack, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	p := &QuestionPack{}
+	if err := json.Unmarshal(data, p); err != nil {
+		return nil, err
+	}
+
+	if p.Name == "" {
+		p.Name = strings.TrimSuffix(filepath.Base(path), ".json")
+	}
+
+	if err := p.validate(); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
@@ -0,0 +1,120 @@
+package main
+
+import "time"
+
+// RateLimit allows Rate messages per second on average, with bursts of up
+// to Burst messages.
+type RateLimit struct {
+	Rate  float64
+	Burst int
+}
+
+// RateLimits are the limits on messages from a single client. Answers and
+// powerup purchases have their own limits, every other opcode shares
+// Message. Messages over a limit are dropped; a client is warned every
+// WarnAfter dropped messages and kicked after KickAfter.
+type RateLimits struct {
+	Submission RateLimit
+	Powerup    RateLimit
+	Message    RateLimit
+
+	WarnAfter int
+	KickAfter int
+}
+
+// rateViolationReset is how long a client has to stay within its limits for
+// its dropped messages to be forgiven.
+const rateViolationReset = 10 * time.Second
+
+func (l RateLimits) forOpcode(opcode byte) RateLimit {
+	switch opcode {
+	case OpcodeSubmission, OpcodeChoiceSubmission:
+		return l.Submission
+	case OpcodePowerup:
+		return l.Powerup
+	default:
+		return l.Message
+	}
+}
+
+// tokenBucket holds up to burst tokens, refilled at rate tokens a second.
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+	limit  RateLimit
+}
+
+func newTokenBucket(limit RateLimit, now time.Time) *tokenBucket {
+	return &tokenBucket{
+		tokens: float64(limit.Burst),
+		last:   now,
+		limit:  limit,
+	}
+}
+
+// take removes a token, reporting false if there was none.
+func (b *tokenBucket) take(now time.Time) bool {
+	b.tokens += now.Sub(b.last).Seconds() * b.limit.Rate
+	b.tokens = min(b.tokens, float64(b.limit.Burst))
+	b.last = now
+
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
+
+type rateVerdict int
+
+const (
+	rateAllow rateVerdict = iota
+	rateDrop
+	rateWarn
+	rateKick
+)
+
+// clientRateLimiter applies RateLimits to the messages of one client. It is
+// owned by the client's read pump.
+type clientRateLimiter struct {
+	limits  RateLimits
+	buckets map[byte]*tokenBucket
+
+	violations    int
+	lastViolation time.Time
+}
+
+func newClientRateLimiter(limits RateLimits) *clientRateLimiter {
+	return &clientRateLimiter{
+		limits:  limits,
+		buckets: map[byte]*tokenBucket{},
+	}
+}
+
+// check decides what to do with a message with the given opcode.
+func (l *clientRateLimiter) check(opcode byte, now time.Time) rateVerdict {
+	b, ok := l.buckets[opcode]
+	if !ok {
+		b = newTokenBucket(l.limits.forOpcode(opcode), now)
+		l.buckets[opcode] = b
+	}
+
+	if b.take(now) {
+		return rateAllow
+	}
+
+	if now.Sub(l.lastViolation) > rateViolationReset {
+		l.violations = 0
+	}
+	l.violations++
+	l.lastViolation = now
+
+	switch {
+	case l.violations >= l.limits.KickAfter:
+		return rateKick
+	case l.violations%l.limits.WarnAfter == 0:
+		return rateWarn
+	default:
+		return rateDrop
+	}
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestClientRateLimiter(t *testing.T) {
+	limits := RateLimits{
+		Submission: RateLimit{Rate: 2, Burst: 3},
+		Powerup:    RateLimit{Rate: 1, Burst: 1},
+		Message:    RateLimit{Rate: 1, Burst: 1},
+		WarnAfter:  2,
+		KickAfter:  5,
+	}
+	start := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	expect := func(t *testing.T, l *clientRateLimiter, now time.Time, want ...rateVerdict) {
+		t.Helper()
+		for i, w := range want {
+			if v := l.check(OpcodeSubmission, now); v != w {
+				t.Fatalf("submission %d at %v: got verdict %d, want %d", i, now.Sub(start), v, w)
+			}
+		}
+	}
+
+	t.Run("escalation", func(t *testing.T) {
+		l := newClientRateLimiter(limits)
+		expect(t, l, start, rateAllow, rateAllow, rateAllow, rateDrop, rateWarn, rateDrop, rateWarn, rateKick)
+
+		// other opcodes have their own buckets
+		if v := l.check(OpcodePowerup, start); v != rateAllow {
+			t.Errorf("powerup after too many submissions: got verdict %d, want allowed", v)
+		}
+	})
+
+	t.Run("refill", func(t *testing.T) {
+		l := newClientRateLimiter(limits)
+		expect(t, l, start, rateAllow, rateAllow, rateAllow)
+		expect(t, l, start.Add(500*time.Millisecond), rateAllow, rateDrop)
+		// the bucket refills up to its burst only
+		expect(t, l, start.Add(time.Minute), rateAllow, rateAllow, rateAllow, rateDrop)
+	})
+
+	t.Run("reset", func(t *testing.T) {
+		l := newClientRateLimiter(limits)
+		expect(t, l, start, rateAllow, rateAllow, rateAllow, rateDrop)
+
+		// a second violation soon after is warned
+		soon := start.Add(rateViolationReset / 2)
+		expect(t, l, soon, rateAllow, rateAllow, rateAllow, rateWarn)
+
+		// after staying within the limits long enough the count starts over
+		later := soon.Add(rateViolationReset + time.Second)
+		expect(t, l, later, rateAllow, rateAllow, rateAllow, rateDrop)
+	})
+}
@@ -0,0 +1,44 @@
+package main
+
+import "math"
+
+// defaultRating is the rating of players that haven't finished a game.
+const defaultRating = 1500
+
+// ratingK is the most a player's rating can change in one game.
+const ratingK = 32
+
+// ratingDeltas computes the rating changes of a game's players from their
+// ratings before the game and their finishing places, 1 being the winner.
+// Every pair of players is scored as an Elo match won by the better placed
+// one, and the sum is scaled so that a game changes a rating by at most
+// ratingK no matter how many played.
+func ratingDeltas(ratings []float64, places []int) []float64 {
+	deltas := make([]float64, len(ratings))
+	if len(ratings) < 2 {
+		return deltas
+	}
+
+	for i := range ratings {
+		var sum float64
+		for j := range ratings {
+			if i == j {
+				continue
+			}
+
+			var score float64
+			switch {
+			case places[i] < places[j]:
+				score = 1
+			case places[i] == places[j]:
+				score = 0.5
+			}
+
+			expected := 1 / (1 + math.Pow(10, (ratings[j]-ratings[i])/400))
+			sum += score - expected
+		}
+		deltas[i] = ratingK * sum / float64(len(ratings)-1)
+	}
+
+	return deltas
+}
@@ -1,33 +1,120 @@
 package main
 
 import (
-	"log"
+	"context"
+	"errors"
+	"log/slog"
 	"net/http"
 	"os"
+	"os/signal"
+	"syscall"
+	"time"
 )
 
-func serve() {
-	mux := routes()
-	log.Println("listening and serving on 0.0.0.0:8080")
-	http.ListenAndServe("0.0.0.0:8080", mux)
-}
+// shutdownTimeout bounds how long the http server may take to shut down
+// once the lobbies are drained.
+const shutdownTimeout = 5 * time.Second
 
-func routes() *http.ServeMux {
-	mux := http.NewServeMux()
+func serve(config Config) {
+	packs, err := LoadQuestionPacks(config.QuestionPackDir)
+	if err != nil {
+		slog.Error("error loading question packs", "err", err)
+	}
+	slog.Info("loaded question packs", "packs", len(packs))
 
-	mux.Handle("/", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		path := "../frontend/dist" + r.URL.Path
-		if _, err := os.Stat(path); os.IsNotExist(err) || r.URL.Path == "/" {
-			http.ServeFile(w, r, "../frontend/dist/index.html")
-			return
-		}
-		http.FileServer(http.Dir("../frontend/dist")).ServeHTTP(w, r)
-	}))
+	store, err := OpenStore(config.DataDir)
+	if err != nil {
+		slog.Error("error opening store", "err", err)
+		os.Exit(1)
+	}
+	defer store.Close()
 
-	hub := NewHub()
+	hub := NewHub(config, packs, store)
 	go hub.Run()
 
+	handler, err := routes(config, hub)
+	if err != nil {
+		slog.Error("error setting up routes", "err", err)
+		os.Exit(1)
+	}
+
+	server := &http.Server{
+		Addr:    config.ListenAddr,
+		Handler: handler,
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	go func() {
+		slog.Info("listening", "addr", config.ListenAddr)
+		err := server.ListenAndServe()
+		if err != nil && !errors.Is(err, http.ErrServerClosed) {
+			slog.Error("error serving", "err", err)
+			os.Exit(1)
+		}
+	}()
+
+	// the admin listener keeps running until the process exits so lobbies
+	// can still be inspected while draining
+	if config.AdminAddr != "" {
+		go func() {
+			slog.Info("admin listening", "addr", config.AdminAddr)
+			err := http.ListenAndServe(config.AdminAddr, adminRoutes(hub))
+			if err != nil {
+				slog.Error("error serving admin", "err", err)
+				os.Exit(1)
+			}
+		}()
+	}
+
+	<-ctx.Done()
+	// a second signal kills the server right away
+	stop()
+
+	slog.Info("shutting down, draining lobbies", "max_drain_time", config.MaxDrainTime)
+
+	drainCtx, cancelDrain := context.WithTimeout(context.Background(), config.MaxDrainTime)
+	defer cancelDrain()
+	hub.Drain(drainCtx)
+
+	shutdownCtx, cancelShutdown := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancelShutdown()
+	if err := server.Shutdown(shutdownCtx); err != nil {
+		slog.Error("error shutting down server", "err", err)
+	}
+
+	slog.Info("server stopped")
+}
+
+func routes(config Config, hub *Hub) (*http.ServeMux, error) {
+	mux := http.NewServeMux()
+
+	frontend, err := newFrontendHandler(config.FrontendDir, config.Dev)
+	if err != nil {
+		return nil, err
+	}
+	mux.Handle("/", frontend)
+
 	mux.HandleFunc("/ws", hub.ServeWs)
+	mux.HandleFunc("GET /lobbies", hub.ServeLobbies)
+	mux.HandleFunc("GET /metrics", hub.ServeMetrics)
+	mux.HandleFunc("GET /players/{id}", hub.store.ServeProfile)
+	mux.HandleFunc("GET /players/{id}/matches", hub.store.ServePlayerMatches)
+	mux.HandleFunc("GET /matches/{id}", hub.store.ServeMatch)
+	mux.HandleFunc("GET /leaderboards/{period}/{category}", hub.store.ServeLeaderboard)
+
+	mux.HandleFunc("GET /healthz", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok\n"))
+	})
+	// a draining server finishes its games but takes no new players
+	mux.HandleFunc("GET /readyz", func(w http.ResponseWriter, r *http.Request) {
+		if hub.draining.Load() {
+			http.Error(w, "draining", http.StatusServiceUnavailable)
+			return
+		}
+		w.Write([]byte("ok\n"))
+	})
 
-	return mux
+	return mux, nil
 }
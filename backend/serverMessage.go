@@ -27,6 +27,16 @@ const (
 	OpcodeOpponentScoreChanged
 	OpcodeMultipliersChanged
 	OpcodeStartGame
+	OpcodeNewChoiceQuestion
+	OpcodeLeaderboardSnapshot
+	OpcodeIdleWarning
+	OpcodeServerShuttingDown
+	OpcodeRateLimited
+	OpcodeAnnouncement
+	OpcodeGameResults
+	OpcodeAccountCreated
+	OpcodeGlobalLeaderboard
+	OpcodeAchievementUnlocked
 )
 
 // -------- Helper Types --------
@@ -50,12 +60,21 @@ func (p Player) MarshalBinary() ([]byte, error) {
 
 // -------- Hub Greeting --------
 
-type HubGreeting struct{}
+// Hub greeting statuses. A client greeted with anything but HubStatusOK is
+// disconnected right after.
+const (
+	HubStatusOK byte = iota
+	HubStatusServerFull
+)
+
+type HubGreeting struct {
+	Status byte
+}
 
 func (HubGreeting) Opcode() byte { return OpcodeHubGreeting }
 
-func (HubGreeting) MarshalBinary() ([]byte, error) {
-	return []byte{OpcodeHubGreeting}, nil
+func (hg HubGreeting) MarshalBinary() ([]byte, error) {
+	return []byte{OpcodeHubGreeting, hg.Status}, nil
 }
 
 // -------- Lobby Greeting --------
@@ -264,3 +283,254 @@ func (StartGame) Opcode() byte { return OpcodeStartGame }
 func (StartGame) MarshalBinary() ([]byte, error) {
 	return []byte{OpcodeStartGame}, nil
 }
+
+// -------- New Choice Question --------
+
+type NewChoiceQuestion struct {
+	Difficulty byte
+	Question   string
+	Choices    []int32
+}
+
+func (NewChoiceQuestion) Opcode() byte { return OpcodeNewChoiceQuestion }
+
+func (nq NewChoiceQuestion) MarshalBinary() ([]byte, error) {
+	qLen := len(nq.Question)
+	if qLen > 65535 {
+		return nil, errors.New("question too long")
+	}
+	if len(nq.Choices) > 255 {
+		return nil, errors.New("too many choices")
+	}
+	data := make([]byte, 4+qLen+1+4*len(nq.Choices))
+	data[0] = OpcodeNewChoiceQuestion
+	data[1] = nq.Difficulty
+	binary.BigEndian.PutUint16(data[2:], uint16(qLen))
+	copy(data[4:], nq.Question)
+	data[4+qLen] = byte(len(nq.Choices))
+	for i, c := range nq.Choices {
+		binary.BigEndian.PutUint32(data[5+qLen+i*4:], uint32(c))
+	}
+	return data, nil
+}
+
+// -------- Leaderboard Snapshot --------
+
+type LeaderboardEntry struct {
+	PlayerID byte
+	Score    uint32
+	Rank     byte
+}
+
+type LeaderboardSnapshot struct {
+	Entries []LeaderboardEntry
+}
+
+func (LeaderboardSnapshot) Opcode() byte { return OpcodeLeaderboardSnapshot }
+
+func (l LeaderboardSnapshot) MarshalBinary() ([]byte, error) {
+	count := len(l.Entries)
+	if count > 255 {
+		return nil, errors.New("too many leaderboard entries")
+	}
+	data := make([]byte, 1+1+6*count)
+	data[0] = OpcodeLeaderboardSnapshot
+	data[1] = byte(count)
+	for i, e := range l.Entries {
+		offset := 2 + i*6
+		data[offset] = e.PlayerID
+		binary.BigEndian.PutUint32(data[offset+1:], e.Score)
+		data[offset+5] = e.Rank
+	}
+	return data, nil
+}
+
+// -------- Idle Warning --------
+
+type IdleWarning struct {
+	// SecondsLeft until the player is eliminated, 0 if they won't be
+	SecondsLeft uint16
+}
+
+func (IdleWarning) Opcode() byte { return OpcodeIdleWarning }
+
+func (w IdleWarning) MarshalBinary() ([]byte, error) {
+	data := make([]byte, 1+2)
+	data[0] = OpcodeIdleWarning
+	binary.BigEndian.PutUint16(data[1:], w.SecondsLeft)
+	return data, nil
+}
+
+// -------- Server Shutting Down --------
+
+type ServerShuttingDown struct {
+	// SecondsLeft until running games are cut off
+	SecondsLeft uint16
+}
+
+func (ServerShuttingDown) Opcode() byte { return OpcodeServerShuttingDown }
+
+func (s ServerShuttingDown) MarshalBinary() ([]byte, error) {
+	data := make([]byte, 1+2)
+	data[0] = OpcodeServerShuttingDown
+	binary.BigEndian.PutUint16(data[1:], s.SecondsLeft)
+	return data, nil
+}
+
+// -------- Rate Limited --------
+
+// RateLimited warns a client that its messages with the Throttled opcode are
+// being dropped for being sent too fast. Keeping on gets it disconnected.
+type RateLimited struct {
+	Throttled byte
+}
+
+func (RateLimited) Opcode() byte { return OpcodeRateLimited }
+
+func (r RateLimited) MarshalBinary() ([]byte, error) {
+	return []byte{OpcodeRateLimited, r.Throttled}, nil
+}
+
+// -------- Announcement --------
+
+// Announcement is a message from the operators shown to every player.
+type Announcement struct {
+	Text string
+}
+
+func (Announcement) Opcode() byte { return OpcodeAnnouncement }
+
+func (a Announcement) MarshalBinary() ([]byte, error) {
+	if len(a.Text) > 65535 {
+		return nil, errors.New("announcement too long")
+	}
+	data := make([]byte, 1+2+len(a.Text))
+	data[0] = OpcodeAnnouncement
+	binary.BigEndian.PutUint16(data[1:], uint16(len(a.Text)))
+	copy(data[3:], a.Text)
+	return data, nil
+}
+
+// -------- Game Results --------
+
+type ResultEntry struct {
+	PlayerID    byte
+	Place       byte
+	Score       uint32
+	RatingDelta int16
+}
+
+// GameResults ends a game for a player with its place, its new rating and
+// how much that changed, along with the final standings of everyone.
+type GameResults struct {
+	Place       byte
+	Rating      uint16
+	RatingDelta int16
+	Standings   []ResultEntry
+}
+
+func (GameResults) Opcode() byte { return OpcodeGameResults }
+
+func (r GameResults) MarshalBinary() ([]byte, error) {
+	count := len(r.Standings)
+	if count > 255 {
+		return nil, errors.New("too many result entries")
+	}
+	data := make([]byte, 1+1+2+2+1+8*count)
+	data[0] = OpcodeGameResults
+	data[1] = r.Place
+	binary.BigEndian.PutUint16(data[2:], r.Rating)
+	binary.BigEndian.PutUint16(data[4:], uint16(r.RatingDelta))
+	data[6] = byte(count)
+	for i, e := range r.Standings {
+		offset := 7 + i*8
+		data[offset] = e.PlayerID
+		data[offset+1] = e.Place
+		binary.BigEndian.PutUint32(data[offset+2:], e.Score)
+		binary.BigEndian.PutUint16(data[offset+6:], uint16(e.RatingDelta))
+	}
+	return data, nil
+}
+
+// -------- Account Created --------
+
+// AccountCreated gives a client without an account the credentials of its
+// new one, which it should present when connecting from now on.
+type AccountCreated struct {
+	PlayerID string
+	Token    string
+}
+
+func (AccountCreated) Opcode() byte { return OpcodeAccountCreated }
+
+func (a AccountCreated) MarshalBinary() ([]byte, error) {
+	if len(a.PlayerID) > 255 || len(a.Token) > 255 {
+		return nil, errors.New("credentials too long")
+	}
+	data := make([]byte, 0, 3+len(a.PlayerID)+len(a.Token))
+	data = append(data, OpcodeAccountCreated, byte(len(a.PlayerID)))
+	data = append(data, a.PlayerID...)
+	data = append(data, byte(len(a.Token)))
+	data = append(data, a.Token...)
+	return data, nil
+}
+
+// -------- Global Leaderboard --------
+
+// GlobalLeaderboardEntry is a player's place on a global leaderboard. A rank
+// of 0 means the player isn't on it.
+type GlobalLeaderboardEntry struct {
+	Rank  uint16
+	Value uint32
+	Name  string
+}
+
+// GlobalLeaderboard answers a LeaderboardRequest with the top of the
+// leaderboard and the requesting player's own entry.
+type GlobalLeaderboard struct {
+	Period   byte
+	Category byte
+	// ResetsIn is the number of seconds until the period ends, 0 for all
+	// time
+	ResetsIn uint32
+	Own      GlobalLeaderboardEntry
+	Entries  []GlobalLeaderboardEntry
+}
+
+func (GlobalLeaderboard) Opcode() byte { return OpcodeGlobalLeaderboard }
+
+func (g GlobalLeaderboard) MarshalBinary() ([]byte, error) {
+	if len(g.Entries) > 255 {
+		return nil, errors.New("too many leaderboard entries")
+	}
+	data := make([]byte, 0, 14+len(g.Entries)*16)
+	data = append(data, OpcodeGlobalLeaderboard, g.Period, g.Category)
+	data = binary.BigEndian.AppendUint32(data, g.ResetsIn)
+	data = binary.BigEndian.AppendUint16(data, g.Own.Rank)
+	data = binary.BigEndian.AppendUint32(data, g.Own.Value)
+	data = append(data, byte(len(g.Entries)))
+	for _, e := range g.Entries {
+		if len(e.Name) > 255 {
+			return nil, errors.New("player name too long")
+		}
+		data = binary.BigEndian.AppendUint16(data, e.Rank)
+		data = binary.BigEndian.AppendUint32(data, e.Value)
+		data = append(data, byte(len(e.Name)))
+		data = append(data, e.Name...)
+	}
+	return data, nil
+}
+
+// -------- Achievement Unlocked --------
+
+// AchievementUnlocked tells a player it unlocked an achievement, see
+// StreakAchievement.
+type AchievementUnlocked struct {
+	Achievement byte
+}
+
+func (AchievementUnlocked) Opcode() byte { return OpcodeAchievementUnlocked }
+
+func (a AchievementUnlocked) MarshalBinary() ([]byte, error) {
+	return []byte{OpcodeAchievementUnlocked, a.Achievement}, nil
+}
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"cmp"
+	"math"
+	"net/http"
+	"slices"
+	"time"
+)
+
+// favouritePowerupCount is the number of favourite powerups in a player's
+// stats.
+const favouritePowerupCount = 3
+
+// answerTotals counts a client's answers to the questions of one difficulty
+// during a game.
+type answerTotals struct {
+	answers uint
+	correct uint
+	time    time.Duration
+}
+
+// PlayerStats are a player's totals over all their recorded matches.
+type PlayerStats struct {
+	GamesPlayed  int     `json:"gamesPlayed"`
+	Wins         int     `json:"wins"`
+	AveragePlace float64 `json:"averagePlace"`
+	BestScore    uint    `json:"bestScore"`
+
+	CorrectAnswers uint `json:"correctAnswers"`
+	WrongAnswers   uint `json:"wrongAnswers"`
+	// Accuracy is the share of answers that were correct
+	Accuracy     float64           `json:"accuracy"`
+	Difficulties []DifficultyStats `json:"difficulties"`
+
+	CoinsEarned uint `json:"coinsEarned"`
+	CoinsSpent  uint `json:"coinsSpent"`
+	// FavouritePowerups are the powerups bought most, the most bought first
+	FavouritePowerups []PowerupCount `json:"favouritePowerups"`
+}
+
+// DifficultyStats are a player's answers to the questions of one difficulty.
+type DifficultyStats struct {
+	Difficulty           uint    `json:"difficulty"`
+	Answers              uint    `json:"answers"`
+	Accuracy             float64 `json:"accuracy"`
+	AverageAnswerSeconds float64 `json:"averageAnswerSeconds"`
+}
+
+type PowerupCount struct {
+	Name  string `json:"name"`
+	Count uint   `json:"count"`
+}
+
+// Profile is the public part of a player's account along with their stats.
+type Profile struct {
+	ID       string      `json:"id"`
+	Name     string      `json:"name"`
+	Rating   float64     `json:"rating"`
+	Created  time.Time   `json:"created"`
+	LastSeen time.Time   `json:"lastSeen"`
+	Stats    PlayerStats `json:"stats"`
+
+	Achievements []UnlockedAchievement `json:"achievements"`
+}
+
+// PlayerStats aggregates the player's recorded matches.
+func (s *Store) PlayerStats(playerID string) PlayerStats {
+	stats := PlayerStats{
+		Difficulties:      []DifficultyStats{},
+		FavouritePowerups: []PowerupCount{},
+	}
+
+	var places int
+	difficulties := map[uint]*DifficultyTotals{}
+	powerups := map[string]uint{}
+
+	for _, m := range s.PlayerMatches(playerID, math.MaxInt) {
+		i := slices.IndexFunc(m.Players, func(p MatchPlayer) bool {
+			return p.PlayerID == playerID
+		})
+		p := m.Players[i]
+
+		stats.GamesPlayed++
+		if p.Place == 1 {
+			stats.Wins++
+		}
+		places += p.Place
+		stats.BestScore = max(stats.BestScore, p.Score)
+
+		stats.CorrectAnswers += p.CorrectAnswers
+		stats.WrongAnswers += p.WrongAnswers
+		stats.CoinsEarned += p.CoinsEarned
+		stats.CoinsSpent += p.CoinsSpent
+
+		for _, d := range p.Difficulties {
+			t, ok := difficulties[d.Difficulty]
+			if !ok {
+				t = &DifficultyTotals{Difficulty: d.Difficulty}
+				difficulties[d.Difficulty] = t
+			}
+			t.Answers += d.Answers
+			t.Correct += d.Correct
+			t.AnswerSeconds += d.AnswerSeconds
+		}
+
+		for name, n := range p.Powerups {
+			powerups[name] += n
+		}
+	}
+
+	if stats.GamesPlayed > 0 {
+		stats.AveragePlace = float64(places) / float64(stats.GamesPlayed)
+	}
+	if answers := stats.CorrectAnswers + stats.WrongAnswers; answers > 0 {
+		stats.Accuracy = float64(stats.CorrectAnswers) / float64(answers)
+	}
+
+	for _, t := range difficulties {
+		stats.Difficulties = append(stats.Difficulties, DifficultyStats{
+			Difficulty:           t.Difficulty,
+			Answers:              t.Answers,
+			Accuracy:             float64(t.Correct) / float64(t.Answers),
+			AverageAnswerSeconds: t.AnswerSeconds / float64(t.Answers),
+		})
+	}
+	slices.SortFunc(stats.Difficulties, func(a, b DifficultyStats) int {
+		return cmp.Compare(a.Difficulty, b.Difficulty)
+	})
+
+	for name, n := range powerups {
+		stats.FavouritePowerups = append(stats.FavouritePowerups, PowerupCount{Name: name, Count: n})
+	}
+	slices.SortFunc(stats.FavouritePowerups, func(a, b PowerupCount) int {
+		return cmp.Or(cmp.Compare(b.Count, a.Count), cmp.Compare(a.Name, b.Name))
+	})
+	stats.FavouritePowerups = stats.FavouritePowerups[:min(len(stats.FavouritePowerups), favouritePowerupCount)]
+
+	return stats
+}
+
+// ServeProfile returns a player's profile as JSON.
+func (s *Store) ServeProfile(w http.ResponseWriter, r *http.Request) {
+	a, ok := s.Account(r.PathValue("id"))
+	if !ok {
+		writeError(w, http.StatusNotFound, errAccountNotFound)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, Profile{
+		ID:       a.ID,
+		Name:     a.Name,
+		Rating:   a.Rating,
+		Created:  a.Created,
+		LastSeen: a.LastSeen,
+		Stats:    s.PlayerStats(a.ID),
+
+		Achievements: unlockedAchievements(a),
+	})
+}
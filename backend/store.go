@@ -0,0 +1,211 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// Store keeps the server's persistent data in files in a directory. It needs
+// no database: every collection is held in memory and written through to
+// its own file.
+type Store struct {
+	accounts *collection[Account]
+	matches  *collection[Match]
+
+	// playerMatches indexes the IDs of every player's matches, oldest first
+	mu            sync.Mutex
+	playerMatches map[string][]string
+
+	leaderboards *leaderboards
+}
+
+// OpenStore opens the store in dir, creating it if it doesn't exist.
+func OpenStore(dir string) (*Store, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+
+	accounts, err := openCollection[Account](filepath.Join(dir, "accounts.jsonl"))
+	if err != nil {
+		return nil, err
+	}
+
+	matches, err := openCollection[Match](filepath.Join(dir, "matches.jsonl"))
+	if err != nil {
+		accounts.close()
+		return nil, err
+	}
+
+	s := &Store{
+		accounts:      accounts,
+		matches:       matches,
+		playerMatches: map[string][]string{},
+		leaderboards:  newLeaderboards(),
+	}
+	s.indexMatches()
+
+	return s, nil
+}
+
+func (s *Store) Close() error {
+	return errors.Join(s.accounts.close(), s.matches.close())
+}
+
+// collection is a set of records keyed by string, stored as a log of JSON
+// lines that each set the record of one key. The log is compacted to the
+// latest record of every key when it is opened.
+type collection[T any] struct {
+	mu      sync.Mutex
+	path    string
+	file    *os.File
+	records map[string]T
+}
+
+type logEntry[T any] struct {
+	Key   string `json:"key"`
+	Value T      `json:"value"`
+}
+
+func openCollection[T any](path string) (*collection[T], error) {
+	c := &collection[T]{path: path, records: map[string]T{}}
+
+	if err := c.load(); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	if err := c.compact(); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o644)
+	if err != nil {
+		return nil, err
+	}
+	c.file = f
+
+	return c, nil
+}
+
+// load replays the log.
+func (c *collection[T]) load() error {
+	f, err := os.Open(c.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	s := bufio.NewScanner(f)
+	s.Buffer(nil, 16<<20)
+
+	var bad error
+	for line := 1; s.Scan(); line++ {
+		// a crash can leave the last line half written, which is dropped,
+		// but a broken line before others means the file is corrupt
+		if bad != nil {
+			return bad
+		}
+
+		var e logEntry[T]
+		if err := json.Unmarshal(s.Bytes(), &e); err != nil {
+			bad = fmt.Errorf("line %d: %w", line, err)
+			continue
+		}
+		c.records[e.Key] = e.Value
+	}
+	if bad != nil {
+		slog.Warn("dropping truncated record", "path", c.path, "err", bad)
+	}
+	return s.Err()
+}
+
+// compact rewrites the log with one line per record, replacing the old one
+// atomically.
+func (c *collection[T]) compact() error {
+	tmp, err := os.CreateTemp(filepath.Dir(c.path), filepath.Base(c.path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	w := bufio.NewWriter(tmp)
+	e := json.NewEncoder(w)
+	for key, v := range c.records {
+		if err := e.Encode(logEntry[T]{Key: key, Value: v}); err != nil {
+			tmp.Close()
+			return err
+		}
+	}
+
+	if err := w.Flush(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), c.path)
+}
+
+func (c *collection[T]) get(key string) (T, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	v, ok := c.records[key]
+	return v, ok
+}
+
+// put sets the record of key and appends it to the log.
+func (c *collection[T]) put(key string, v T) error {
+	return c.update(key, func(p *T) { *p = v })
+}
+
+// update applies f to the record of key under the collection's lock, so
+// concurrent updates of a record don't overwrite each other. f is given the
+// zero value if there is no record yet.
+func (c *collection[T]) update(key string, f func(v *T)) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	v := c.records[key]
+	f(&v)
+
+	line, err := json.Marshal(logEntry[T]{Key: key, Value: v})
+	if err != nil {
+		return err
+	}
+
+	c.records[key] = v
+	_, err = c.file.Write(append(line, '\n'))
+	return err
+}
+
+// each calls f with every record in no particular order. f must not use the
+// collection.
+func (c *collection[T]) each(f func(key string, v T)) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for key, v := range c.records {
+		f(key, v)
+	}
+}
+
+func (c *collection[T]) close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.file.Close()
+}
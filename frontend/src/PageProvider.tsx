@@ -1,6 +1,6 @@
 import React, { createContext, useContext, useState } from "react";
-import type { CorrectSubmission, LobbyHello, NewPlayer, NewQuestion, Player, PurchaseConfirmed, Socket, StatusChanged, StatusEffectId, OpponentStatusChanged, OpponentEliminated, MultipliersChanged, StartGame, OpponentScoreChanged} from './lib/comm.ts';
-import { connect as socketConnect } from './lib/comm.ts';
+import type { HubHello, Announcement, CorrectSubmission, LobbyHello, NewPlayer, NewQuestion, Player, PurchaseConfirmed, Socket, StatusChanged, StatusEffectId, OpponentStatusChanged, OpponentEliminated, MultipliersChanged, StartGame, OpponentScoreChanged, LeaderboardSnapshot} from './lib/comm.ts';
+import { connect as socketConnect, HubStatus } from './lib/comm.ts';
 
 export const enum CurrentPage {
   Login,
@@ -46,7 +46,10 @@ function connect(
 ): (name: string) => Promise<void> {
     return async (name: string) => {
         const socket = await socketConnect(name);
-        socket.onHubHello((_) => {});
+        socket.onHubHello((m: HubHello) => {
+            if (m.status == HubStatus.ServerFull)
+                alert("The server is full, please try again later.");
+        });
         socket.onLobbyHello((m: LobbyHello) => {
             setPlayers((pm) => m.players.reduce<PlayerMap>(
                 (a, c) => {
@@ -98,10 +101,23 @@ function connect(
                 return i;
             })
         })
+        socket.onLeaderboardSnapshot((m: LeaderboardSnapshot) => {
+            setPlayers((i) => {
+                for (const e of m.entries) {
+                    if (!i[e.playerId]) continue;
+                    i[e.playerId].score = e.score;
+                    i[e.playerId].rank = e.rank;
+                }
+                return i;
+            })
+        })
         socket.onMultipliersChanged((m: MultipliersChanged) => {
             setScoreMultiplier(m.scoreMultiplier);
             setCoinMultiplier(m.coinMultiplier);
         })
+        socket.onAnnouncement((m: Announcement) => {
+            alert(m.text);
+        })
         socket.OnStartGame((_: StartGame) => {
             setPage(CurrentPage.Game)
         })
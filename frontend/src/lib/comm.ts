@@ -18,16 +18,46 @@ export const StatusEffect = {
 
 export type StatusEffectId = typeof StatusEffect[keyof typeof StatusEffect]
 
+export const Achievement = {
+    Streak: 0,
+    NoPowerupWin: 1,
+    FastDifficulty: 2,
+    HardModeEliminations: 3,
+} as const;
+
+export type AchievementId = typeof Achievement[keyof typeof Achievement]
+
 export const ClientOp = {
     Register: 0,
     Submit: 1,
     Purchase: 2,
     SkipWait: 3,
+    ChoiceSubmit: 4,
+    LeaderboardRequest: 5,
+} as const
+
+export const LeaderboardPeriod = {
+    AllTime: 0,
+    Weekly: 1,
+    Daily: 2,
+} as const
+
+export type LeaderboardPeriodId = typeof LeaderboardPeriod[keyof typeof LeaderboardPeriod]
+
+export const LeaderboardCategory = {
+    Score: 0,
+    CorrectAnswers: 1,
+    Rating: 2,
 } as const
 
+export type LeaderboardCategoryId = typeof LeaderboardCategory[keyof typeof LeaderboardCategory]
+
 export type RegisterMessage = {
     opcode: typeof ClientOp.Register;
     name: string
+    // the credentials of the player's account, if it has one
+    playerId?: string
+    token?: string
 }
 
 export type SubmitMessage = {
@@ -45,7 +75,19 @@ export type SkipWaitMessage = {
     opcode: typeof ClientOp.SkipWait
 }
 
-export type ClientMessage = RegisterMessage | SubmitMessage | PurchaseMessage | SkipWaitMessage;
+export type ChoiceSubmitMessage = {
+    opcode: typeof ClientOp.ChoiceSubmit
+    choice: number
+}
+
+export type LeaderboardRequestMessage = {
+    opcode: typeof ClientOp.LeaderboardRequest
+    period: LeaderboardPeriodId
+    category: LeaderboardCategoryId
+}
+
+export type ClientMessage = RegisterMessage | SubmitMessage | PurchaseMessage | SkipWaitMessage | ChoiceSubmitMessage
+    | LeaderboardRequestMessage;
 
 export const ServerOp = {
     HubHello: 0,
@@ -61,18 +103,37 @@ export const ServerOp = {
     OpponentScoreChanged: 10,
     MultipliersChanged: 11,
     StartGame: 12,
+    NewChoiceQuestion: 13,
+    LeaderboardSnapshot: 14,
+    IdleWarning: 15,
+    ServerShuttingDown: 16,
+    RateLimited: 17,
+    Announcement: 18,
+    GameResults: 19,
+    AccountCreated: 20,
+    GlobalLeaderboard: 21,
+    AchievementUnlocked: 22,
+} as const
+
+export const HubStatus = {
+    Ok: 0,
+    ServerFull: 1,
 } as const
 
+export type HubStatusId = typeof HubStatus[keyof typeof HubStatus]
+
 export type Player = {
     id: number
     name: string
     statusEffects: StatusEffectId[]
     eliminated: boolean
     score: number
+    rank: number
 }
 
 export type HubHello = {
     opcode: typeof ServerOp.HubHello;
+    status: HubStatusId
 }
 
 export type LobbyHello = {
@@ -138,13 +199,97 @@ export type StartGame = {
     opcode: typeof ServerOp.StartGame
 }
 
+export type NewChoiceQuestion = {
+    opcode: typeof ServerOp.NewChoiceQuestion
+    difficulty: number
+    question: string
+    choices: number[]
+}
+
+export type LeaderboardEntry = {
+    playerId: number
+    score: number
+    rank: number
+}
+
+export type LeaderboardSnapshot = {
+    opcode: typeof ServerOp.LeaderboardSnapshot
+    entries: LeaderboardEntry[]
+}
+
+export type IdleWarning = {
+    opcode: typeof ServerOp.IdleWarning
+    secondsLeft: number
+}
+
+export type ServerShuttingDown = {
+    opcode: typeof ServerOp.ServerShuttingDown
+    secondsLeft: number
+}
+
+export type RateLimited = {
+    opcode: typeof ServerOp.RateLimited
+    throttled: number
+}
+
+export type Announcement = {
+    opcode: typeof ServerOp.Announcement
+    text: string
+}
+
+export type ResultEntry = {
+    playerId: number
+    place: number
+    score: number
+    ratingDelta: number
+}
+
+export type GameResults = {
+    opcode: typeof ServerOp.GameResults
+    place: number
+    rating: number
+    ratingDelta: number
+    standings: ResultEntry[]
+}
+
+export type AccountCreated = {
+    opcode: typeof ServerOp.AccountCreated
+    playerId: string
+    token: string
+}
+
+export type GlobalLeaderboardEntry = {
+    rank: number // 0 if not on the leaderboard
+    value: number
+    name: string
+}
+
+export type GlobalLeaderboard = {
+    opcode: typeof ServerOp.GlobalLeaderboard
+    period: LeaderboardPeriodId
+    category: LeaderboardCategoryId
+    resetsIn: number // seconds, 0 for all time
+    own: GlobalLeaderboardEntry
+    entries: GlobalLeaderboardEntry[]
+}
+
+export type AchievementUnlocked = {
+    opcode: typeof ServerOp.AchievementUnlocked
+    achievement: AchievementId
+}
+
 export type ServerMessage = HubHello | LobbyHello
     | NewPlayer | CorrectSubmission
     | NewQuestion | PurchaseConfirmed
     | StatusChanged | OpponentStatusChanged
     | Eliminated | OpponentEliminated
     | OpponentScoreChanged | MultipliersChanged
-    | StartGame;
+    | StartGame | NewChoiceQuestion
+    | LeaderboardSnapshot | IdleWarning
+    | ServerShuttingDown | RateLimited
+    | Announcement | GameResults
+    | AccountCreated | GlobalLeaderboard
+    | AchievementUnlocked;
 
 const textDecoder = new TextDecoder('utf-8');
 const textEncoder = new TextEncoder();
@@ -154,16 +299,29 @@ function serializeClientMessage(payload: ClientMessage): ArrayBuffer {
     const { opcode } = payload;
     switch (opcode) {
         case 0: // Register
-            // payload: { opcode: 0, name: string }
+            // payload: { opcode: 0, name: string, playerId?: string, token?: string }
             const nameEncoded = textEncoder.encode(payload.name);
             if (nameEncoded.length > 255) throw new Error("Name too long");
-            buffer = new ArrayBuffer(1 + 1 + nameEncoded.length); // opcode + nameLen + name
+            // credentials are appended as idLen + id + tokenLen + token
+            const idEncoded = textEncoder.encode(payload.playerId ?? "");
+            const tokenEncoded = textEncoder.encode(payload.token ?? "");
+            if (idEncoded.length > 255 || tokenEncoded.length > 255) throw new Error("Credentials too long");
+            const credentialsLength = payload.playerId ? 2 + idEncoded.length + tokenEncoded.length : 0;
+            buffer = new ArrayBuffer(1 + 1 + nameEncoded.length + credentialsLength); // opcode + nameLen + name + credentials
             view = new DataView(buffer);
             view.setUint8(0, opcode);
             view.setUint8(1, nameEncoded.length);
             for (let i = 0; i < nameEncoded.length; i++) {
                 view.setUint8(2 + i, nameEncoded[i]);
             }
+            if (credentialsLength > 0) {
+                let offset = 2 + nameEncoded.length;
+                view.setUint8(offset++, idEncoded.length);
+                new Uint8Array(buffer, offset, idEncoded.length).set(idEncoded);
+                offset += idEncoded.length;
+                view.setUint8(offset++, tokenEncoded.length);
+                new Uint8Array(buffer, offset, tokenEncoded.length).set(tokenEncoded);
+            }
             return buffer;
 
         case 1: // Submission
@@ -189,6 +347,21 @@ function serializeClientMessage(payload: ClientMessage): ArrayBuffer {
             view.setUint8(0, opcode);
             return buffer;
 
+        case 4: // Choice Submission
+            buffer = new ArrayBuffer(1 + 1); // opcode + choice index
+            view = new DataView(buffer);
+            view.setUint8(0, opcode);
+            view.setUint8(1, payload.choice);
+            return buffer;
+
+        case 5: // Leaderboard Request
+            buffer = new ArrayBuffer(1 + 1 + 1); // opcode + period + category
+            view = new DataView(buffer);
+            view.setUint8(0, opcode);
+            view.setUint8(1, payload.period);
+            view.setUint8(2, payload.category);
+            return buffer;
+
         default:
             throw new Error("Unknown opcode: " + opcode);
     }
@@ -201,7 +374,7 @@ function parsePlayer(view: DataView, offset: number): [Player, number] {
     const nameBytes = new Uint8Array(view.buffer, view.byteOffset + offset, nameLen);
     const name = textDecoder.decode(nameBytes);
     offset += nameLen;
-    return [{ id: playerId, name, statusEffects: [], eliminated: false, score: 0 }, offset];
+    return [{ id: playerId, name, statusEffects: [], eliminated: false, score: 0, rank: 0 }, offset];
 }
 
 // Helper: parses status effect IDs
@@ -222,8 +395,10 @@ function parseServerMessage(buffer: ArrayBuffer): ServerMessage {
 
     switch (opcode) {
         case 0: // Hub Greeting
-            // No content
-            return { opcode } ;
+            {
+                const status = view.getUint8(offset++) as HubStatusId;
+                return { status, opcode };
+            }
 
         case 1: // Lobby Greeting
             {
@@ -323,6 +498,131 @@ function parseServerMessage(buffer: ArrayBuffer): ServerMessage {
             // No content
             return { opcode } ;
 
+        case 13: // New Choice Question
+            {
+                const difficulty = view.getUint8(offset++);
+                const questionLength = view.getUint16(offset, false); // big-endian
+                offset += 2;
+                const qBytes = new Uint8Array(view.buffer, view.byteOffset + offset, questionLength);
+                const question = textDecoder.decode(qBytes);
+                offset += questionLength;
+                const choiceCount = view.getUint8(offset++);
+                const choices = [];
+                for (let i = 0; i < choiceCount; i++) {
+                    choices.push(view.getInt32(offset, false)); // big-endian
+                    offset += 4;
+                }
+                return { difficulty, question, choices, opcode };
+            }
+
+        case 14: // Leaderboard Snapshot
+            {
+                const entryCount = view.getUint8(offset++);
+                const entries = [];
+                for (let i = 0; i < entryCount; i++) {
+                    const playerId = view.getUint8(offset++);
+                    const score = view.getUint32(offset, false); // big-endian
+                    offset += 4;
+                    const rank = view.getUint8(offset++);
+                    entries.push({ playerId, score, rank });
+                }
+                return { entries, opcode };
+            }
+
+        case 15: // Idle Warning
+            {
+                const secondsLeft = view.getUint16(offset, false); // big-endian
+                offset += 2;
+                return { secondsLeft, opcode };
+            }
+
+        case 16: // Server Shutting Down
+            {
+                const secondsLeft = view.getUint16(offset, false); // big-endian
+                offset += 2;
+                return { secondsLeft, opcode };
+            }
+
+        case 17: // Rate Limited
+            {
+                const throttled = view.getUint8(offset++);
+                return { throttled, opcode };
+            }
+
+        case 18: // Announcement
+            {
+                const textLength = view.getUint16(offset, false); // big-endian
+                offset += 2;
+                const textBytes = new Uint8Array(view.buffer, view.byteOffset + offset, textLength);
+                const text = textDecoder.decode(textBytes);
+                offset += textLength;
+                return { text, opcode };
+            }
+
+        case 19: // Game Results
+            {
+                const place = view.getUint8(offset++);
+                const rating = view.getUint16(offset, false); // big-endian
+                offset += 2;
+                const ratingDelta = view.getInt16(offset, false); // big-endian
+                offset += 2;
+                const entryCount = view.getUint8(offset++);
+                const standings = [];
+                for (let i = 0; i < entryCount; i++) {
+                    const playerId = view.getUint8(offset++);
+                    const place = view.getUint8(offset++);
+                    const score = view.getUint32(offset, false); // big-endian
+                    offset += 4;
+                    const ratingDelta = view.getInt16(offset, false); // big-endian
+                    offset += 2;
+                    standings.push({ playerId, place, score, ratingDelta });
+                }
+                return { place, rating, ratingDelta, standings, opcode };
+            }
+
+        case 20: // Account Created
+            {
+                const idLength = view.getUint8(offset++);
+                const playerId = textDecoder.decode(new Uint8Array(view.buffer, view.byteOffset + offset, idLength));
+                offset += idLength;
+                const tokenLength = view.getUint8(offset++);
+                const token = textDecoder.decode(new Uint8Array(view.buffer, view.byteOffset + offset, tokenLength));
+                offset += tokenLength;
+                return { playerId, token, opcode };
+            }
+
+        case 21: // Global Leaderboard
+            {
+                const period = view.getUint8(offset++) as LeaderboardPeriodId;
+                const category = view.getUint8(offset++) as LeaderboardCategoryId;
+                const resetsIn = view.getUint32(offset, false); // big-endian
+                offset += 4;
+                const ownRank = view.getUint16(offset, false); // big-endian
+                offset += 2;
+                const ownValue = view.getUint32(offset, false); // big-endian
+                offset += 4;
+                const own = { rank: ownRank, value: ownValue, name: "" };
+                const entryCount = view.getUint8(offset++);
+                const entries = [];
+                for (let i = 0; i < entryCount; i++) {
+                    const rank = view.getUint16(offset, false); // big-endian
+                    offset += 2;
+                    const value = view.getUint32(offset, false); // big-endian
+                    offset += 4;
+                    const nameLength = view.getUint8(offset++);
+                    const name = textDecoder.decode(new Uint8Array(view.buffer, view.byteOffset + offset, nameLength));
+                    offset += nameLength;
+                    entries.push({ rank, value, name });
+                }
+                return { period, category, resetsIn, own, entries, opcode };
+            }
+
+        case 22: // Achievement Unlocked
+            {
+                const achievement = view.getUint8(offset++) as AchievementId;
+                return { achievement, opcode };
+            }
+
         default:
             throw new Error('Unknown opcode: ' + opcode);
     }
@@ -356,9 +656,21 @@ export type Socket = {
     onOpponentScoreChanged: (arg0: (arg0: OpponentScoreChanged) => void) => void,
     onMultipliersChanged: (arg0: (arg0: MultipliersChanged) => void) => void,
     OnStartGame: (arg0: (arg0: StartGame) => void) => void,
+    onNewChoiceQuestion: (arg0: (arg0: NewChoiceQuestion) => void) => void,
+    onLeaderboardSnapshot: (arg0: (arg0: LeaderboardSnapshot) => void) => void,
+    onIdleWarning: (arg0: (arg0: IdleWarning) => void) => void,
+    onServerShuttingDown: (arg0: (arg0: ServerShuttingDown) => void) => void,
+    onRateLimited: (arg0: (arg0: RateLimited) => void) => void,
+    onAnnouncement: (arg0: (arg0: Announcement) => void) => void,
+    onGameResults: (arg0: (arg0: GameResults) => void) => void,
+    onAccountCreated: (arg0: (arg0: AccountCreated) => void) => void,
+    onGlobalLeaderboard: (arg0: (arg0: GlobalLeaderboard) => void) => void,
+    onAchievementUnlocked: (arg0: (arg0: AchievementUnlocked) => void) => void,
     sendSubmit: (answer: number) => void
+    sendChoice: (choice: number) => void
     sendPurchase: (powerup: PowerupId, target: number) => void
     sendSkip: () => void
+    sendLeaderboardRequest: (period: LeaderboardPeriodId, category: LeaderboardCategoryId) => void
 }
 
 async function connect_raw(url: string): Promise<Socket> {
@@ -401,14 +713,43 @@ async function connect_raw(url: string): Promise<Socket> {
         onOpponentScoreChanged: (handler: (arg0: OpponentScoreChanged) => void) => callIfOpCode(handler, ServerOp.OpponentScoreChanged),
         onMultipliersChanged: (handler: (arg0: MultipliersChanged) => void) => callIfOpCode(handler, ServerOp.MultipliersChanged),
         OnStartGame: (handler: (arg0: StartGame) => void) => callIfOpCode(handler, ServerOp.StartGame),
+        onNewChoiceQuestion: (handler: (arg0: NewChoiceQuestion) => void) => callIfOpCode(handler, ServerOp.NewChoiceQuestion),
+        onLeaderboardSnapshot: (handler: (arg0: LeaderboardSnapshot) => void) => callIfOpCode(handler, ServerOp.LeaderboardSnapshot),
+        onIdleWarning: (handler: (arg0: IdleWarning) => void) => callIfOpCode(handler, ServerOp.IdleWarning),
+        onServerShuttingDown: (handler: (arg0: ServerShuttingDown) => void) => callIfOpCode(handler, ServerOp.ServerShuttingDown),
+        onRateLimited: (handler: (arg0: RateLimited) => void) => callIfOpCode(handler, ServerOp.RateLimited),
+        onAnnouncement: (handler: (arg0: Announcement) => void) => callIfOpCode(handler, ServerOp.Announcement),
+        onGameResults: (handler: (arg0: GameResults) => void) => callIfOpCode(handler, ServerOp.GameResults),
+        onAccountCreated: (handler: (arg0: AccountCreated) => void) => callIfOpCode(handler, ServerOp.AccountCreated),
+        onGlobalLeaderboard: (handler: (arg0: GlobalLeaderboard) => void) => callIfOpCode(handler, ServerOp.GlobalLeaderboard),
+        onAchievementUnlocked: (handler: (arg0: AchievementUnlocked) => void) => callIfOpCode(handler, ServerOp.AchievementUnlocked),
         sendSubmit: (answer: number) => { socket.send(serializeClientMessage({ opcode: ClientOp.Submit, answer })) },
+        sendChoice: (choice: number) => { socket.send(serializeClientMessage({ opcode: ClientOp.ChoiceSubmit, choice })) },
         sendPurchase: (powerup: PowerupId, targetId: number) => { socket.send(serializeClientMessage({ opcode: ClientOp.Purchase, powerup, targetId })) },
         sendSkip: () => { socket.send(serializeClientMessage({ opcode: ClientOp.SkipWait })) },
+        sendLeaderboardRequest: (period: LeaderboardPeriodId, category: LeaderboardCategoryId) => { socket.send(serializeClientMessage({ opcode: ClientOp.LeaderboardRequest, period, category })) },
     };
 }
 
 export async function connect(name: string): Promise<Socket> {
-    // const proto = (window.location.protocol == "http:") ? "ws://" : "wss://"
-    // return await connect_raw(`${proto}${window.location.host}/ws\?name=${name}`)
-    return await connect_raw("ws://127.0.0.1:8080/ws?name=" + name)
+    // the server serves the page, so its origin is the page's own. The vite
+    // dev server proxies /ws to it.
+    const proto = (window.location.protocol == "http:") ? "ws://" : "wss://"
+    const url = `${proto}${window.location.host}/ws`
+
+    const socket = await connect_raw(url)
+
+    // the credentials go in the register message rather than the url, which
+    // ends up in proxy and access logs. The server creates an account for
+    // players without one.
+    const playerId = localStorage.getItem("playerId") ?? undefined
+    const token = localStorage.getItem("playerToken") ?? undefined
+    socket.socket.addEventListener("open", () => {
+        socket.lowlevel.send({ opcode: ClientOp.Register, name, playerId, token })
+    })
+    socket.onAccountCreated((m: AccountCreated) => {
+        localStorage.setItem("playerId", m.playerId)
+        localStorage.setItem("playerToken", m.token)
+    })
+    return socket
 }